@@ -0,0 +1,563 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"rosman/lib/mikrotik"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type TCommand struct {
+	Name string
+	Note string
+	Func func(args []string) error
+}
+
+var Commands []*TCommand
+
+func init() {
+	Commands = []*TCommand{
+		{Name: "tasks", Note: "tasks next [--host name|ip] [--count n]: preview upcoming run times", Func: CmdTasks},
+		{Name: "history", Note: "history [--host name|ip] [--job name] [--object name] [--since 7d] [--json]: show past runs", Func: CmdHistory},
+		{Name: "run", Note: "run --host name|ip (--job name | --action sync|backup|export|profile) [--force-deletions]: run once and exit", Func: CmdRun},
+		{Name: "import", Note: "import --host name|ip [--write]: read users, groups, schedules and scripts from the device and generate config entries", Func: CmdImport},
+		{Name: "audit", Note: "audit verify: check the hash chain of the audit log", Func: CmdAudit},
+		{Name: "digest", Note: "digest [--since 24h] [--send]: print the change digest or email it to digest recipients", Func: CmdDigest},
+		{Name: "rotate", Note: "rotate --user alias | status: rotate the password of a user on all its hosts or show hosts behind the latest password", Func: CmdRotate},
+		{Name: "rotate-self", Note: "rotate-self [--host name|ip]: change the password of the rosman login host by host, verified by a fresh login and rolled back on failure", Func: CmdRotateSelf},
+		{Name: "grant", Note: "grant list [--all] | add --user alias --host name|ip[,...] [--from time] --until time|period --reason text | revoke --id id: time-limited access of a user", Func: CmdGrant},
+		{Name: "breakglass", Note: "breakglass reveal --host name|ip --reason text | status: show the break-glass password of a host (audited, rotated afterwards) or the rotation state", Func: CmdBreakglass},
+		{Name: "secrets", Note: "secrets init-key | list | get name [--version n]: manage the encrypted store of generated passwords", Func: CmdSecrets},
+	}
+}
+
+func RunCommand(args []string) error {
+	for _, command := range Commands {
+		if command.Name == args[0] {
+			return command.Func(args[1:])
+		}
+	}
+	PrintUsage()
+	return errors.New(fmt.Sprintf("unknown command \"%s\"", args[0]))
+}
+
+func PrintUsage() {
+	fmt.Fprintln(os.Stderr, "usage: rosman [command]")
+	fmt.Fprintln(os.Stderr, "without command the service manages all hosts")
+	for _, command := range Commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", command.Note)
+	}
+}
+
+func GetHosts(selector string) (mikrotik.THosts, error) {
+	if selector == "" {
+		return mikrotik.Hosts, nil
+	}
+	host, err := mikrotik.Hosts.GetByNameOrIP(selector)
+	if err != nil {
+		return nil, err
+	}
+	return mikrotik.THosts{host}, nil
+}
+
+func CmdTasks(args []string) error {
+	if len(args) == 0 || args[0] != "next" {
+		return errors.New("usage: rosman tasks next [--host name|ip] [--count n]")
+	}
+	flags := flag.NewFlagSet("tasks next", flag.ContinueOnError)
+	hostName := flags.String("host", "", "host name or ip")
+	count := flags.Int("count", 5, "number of run times per job")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	hosts, err := GetHosts(*hostName)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tIP\tJOB\tTASK\tNEXT RUN")
+	now := time.Now()
+	for _, host := range hosts {
+		for _, job := range host.Jobs {
+			for _, next := range job.Task.NextN(now, *count) {
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", host.Name, host.IP, job.Name, job.Task.Name, next.Format("2006-01-02 15:04:05 MST -07:00"))
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+func CmdHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	hostName := flags.String("host", "", "host name or ip")
+	jobName := flags.String("job", "", "job name")
+	object := flags.String("object", "", "name of created or deleted user, group or schedule")
+	since := flags.String("since", "", "period (7d, 12h, 2w) or date (2006-01-02)")
+	asJSON := flags.Bool("json", false, "print runs as JSON lines")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	filter := mikrotik.THistoryFilter{Host: *hostName, Job: *jobName, Object: *object}
+	filter.Since, err = mikrotik.ParseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+	runs, err := mikrotik.ReadHistory(filter)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if *asJSON {
+			line, err := json.Marshal(run)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Printf("%s  %s (%s)  job=%s  %s  %s  run=%s\n", run.Start.Format("2006-01-02 15:04:05"), run.Host, run.IP, run.Job, run.Status, run.End.Sub(run.Start).Round(time.Millisecond), run.ID)
+		for _, step := range run.Steps {
+			fmt.Printf("    %-20s %s", step.Name, step.Status)
+			if step.Error != "" {
+				fmt.Printf("  error: \"%s\"", step.Error)
+			}
+			fmt.Println()
+			for _, object := range step.Created {
+				fmt.Printf("        created %s \"%s\"\n", object.Kind, object.Name)
+			}
+			for _, object := range step.Deleted {
+				fmt.Printf("        deleted %s \"%s\" (%s)\n", object.Kind, object.Name, object.Reason)
+			}
+			for _, object := range step.Disabled {
+				fmt.Printf("        disabled %s \"%s\" (%s)\n", object.Kind, object.Name, object.Reason)
+			}
+			for _, object := range step.Enabled {
+				fmt.Printf("        enabled %s \"%s\" (%s)\n", object.Kind, object.Name, object.Reason)
+			}
+			for _, object := range step.Rotated {
+				fmt.Printf("        rotated %s \"%s\" (%s)\n", object.Kind, object.Name, object.Reason)
+			}
+			for _, object := range step.Updated {
+				fmt.Printf("        updated %s \"%s\" (%s)\n", object.Kind, object.Name, object.Reason)
+			}
+			for _, file := range step.Downloaded {
+				fmt.Printf("        downloaded \"%s\" to \"%s\" (%d bytes)\n", file.Remote, file.Local, file.Size)
+			}
+		}
+		for _, hook := range run.Hooks {
+			fmt.Printf("    hook %-15s %s  %s", hook.Name, hook.Event, hook.Status)
+			if hook.Error != "" {
+				fmt.Printf("  error: \"%s\"", hook.Error)
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+func CmdDigest(args []string) error {
+	flags := flag.NewFlagSet("digest", flag.ContinueOnError)
+	since := flags.String("since", "24h", "period (7d, 12h, 2w) or date (2006-01-02)")
+	send := flags.Bool("send", false, "email the digest instead of printing it")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	from, err := mikrotik.ParseSince(*since, to)
+	if err != nil {
+		return err
+	}
+	if *send {
+		return mikrotik.SendDigest(from, to)
+	}
+	config := &mikrotik.TDigestConfig{}
+	if mikrotik.SMTP.Digest != nil {
+		config.Subject = mikrotik.SMTP.Digest.Subject
+		config.Template = mikrotik.SMTP.Digest.Template
+	}
+	err = config.ParseTemplates()
+	if err != nil {
+		return err
+	}
+	digest, err := mikrotik.BuildDigest(from, to)
+	if err != nil {
+		return err
+	}
+	subject, body, err := config.Render(digest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Subject: %s\n\n%s", subject, body)
+	return nil
+}
+
+func CmdAudit(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: rosman audit verify")
+	}
+	count, err := mikrotik.VerifyAudit()
+	if err != nil {
+		return errors.New(fmt.Sprintf("audit log is broken after %d valid entries: %s", count, err))
+	}
+	fmt.Printf("audit log is intact, %d entries\n", count)
+	return nil
+}
+
+func CmdRun(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	hostName := flags.String("host", "", "host name or ip")
+	jobName := flags.String("job", "", "job name")
+	action := flags.String("action", "", "action or profile name")
+	force := flags.Bool("force-deletions", false, "allow more deletions than max_deletions")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *hostName == "" || (*jobName == "") == (*action == "") {
+		return errors.New("usage: rosman run --host name|ip (--job name | --action name) [--force-deletions]")
+	}
+	host, err := mikrotik.Hosts.GetByNameOrIP(*hostName)
+	if err != nil {
+		return err
+	}
+	options := mikrotik.TRunOptions{ForceDeletions: *force}
+	if *action != "" {
+		return host.RunAction(*action, options)
+	}
+	job, err := host.Jobs.GetByName(*jobName)
+	if err != nil {
+		return err
+	}
+	return host.StartJob(job, options)
+}
+
+func CmdImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	hostName := flags.String("host", "", "host name or ip")
+	write := flags.Bool("write", false, "merge entries into config files and write scripts")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *hostName == "" {
+		return errors.New("usage: rosman import --host name|ip [--write]")
+	}
+	host, err := mikrotik.Hosts.GetByNameOrIP(*hostName)
+	if err != nil {
+		return err
+	}
+	result, err := host.Import()
+	if err != nil {
+		return err
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("skip      %s\n", skipped)
+	}
+	for _, group := range result.Groups {
+		fmt.Printf("group     %s\n", group.Name)
+	}
+	for _, user := range result.Users {
+		fmt.Printf("user      %s (alias %s, group %s)\n", user.Login, user.Alias, user.Group)
+	}
+	for _, schedule := range result.Schedules {
+		fmt.Printf("schedule  %s (alias %s, script %s)\n", schedule.Name, schedule.Alias, schedule.Script)
+	}
+	for _, script := range result.Scripts {
+		fmt.Printf("script    %s (file %s)\n", script.Name, script.File)
+	}
+	if len(result.UsersAliases) > 0 || len(result.SchedulesAliases) > 0 {
+		fmt.Printf("host      users_aliases += %v, schedules_aliases += %v\n", result.UsersAliases, result.SchedulesAliases)
+	}
+	if !*write {
+		fmt.Println("dry run, use --write to update config files")
+		return nil
+	}
+	return result.Save()
+}
+
+func CmdSecrets(args []string) error {
+	usage := errors.New("usage: rosman secrets init-key | list | get name [--version n]")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "init-key":
+		path := mikrotik.Params.GetValue("secrets_key_file")
+		if path == "" {
+			return errors.New("param \"secrets_key_file\" is empty")
+		}
+		err := mikrotik.GenerateSecretsKey(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("key written to \"%s\", keep a copy outside of this host\n", path)
+		return nil
+	case "list":
+		secrets, names, err := mikrotik.ListSecrets()
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tVERSION\tCREATED")
+		for _, name := range names {
+			latest := secrets[name][len(secrets[name])-1]
+			fmt.Fprintf(writer, "%s\t%d\t%s\n", name, latest.Version, latest.Created.Format("2006-01-02 15:04:05"))
+		}
+		return writer.Flush()
+	case "get":
+		flags := flag.NewFlagSet("secrets get", flag.ContinueOnError)
+		version := flags.Int("version", 0, "version of the secret, latest by default")
+		if len(args) < 2 {
+			return usage
+		}
+		err := flags.Parse(args[2:])
+		if err != nil {
+			return err
+		}
+		// break-glass passwords are revealed only with an audit record and a
+		// scheduled rotation
+		if strings.HasPrefix(args[1], mikrotik.BreakglassSecretName("")) {
+			return errors.New(fmt.Sprintf("secret \"%s\" is a break-glass password, use rosman breakglass reveal --host X --reason text", args[1]))
+		}
+		value, _, err := mikrotik.GetSecret(args[1], *version)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	}
+	return usage
+}
+
+func CmdRotate(args []string) error {
+	if len(args) > 0 && args[0] == "status" {
+		return PrintRotationStatus()
+	}
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	alias := flags.String("user", "", "user alias")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *alias == "" {
+		return errors.New("usage: rosman rotate --user alias | status")
+	}
+	user := mikrotik.Users.GetByAlias(*alias)
+	if user == nil {
+		return errors.New(fmt.Sprintf("user \"%s\" does not exist", *alias))
+	}
+	version, err := mikrotik.NewUserPassword(user)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, host := range mikrotik.Hosts {
+		if !host.Users.IsContain(user.Login) {
+			continue
+		}
+		err = host.RunAction("rotate", mikrotik.TRunOptions{})
+		if err != nil {
+			fmt.Printf("%-20s %-15s failed: %s\n", host.Name, host.IP, err)
+			failed = append(failed, host.Name)
+			continue
+		}
+		fmt.Printf("%-20s %-15s version %d\n", host.Name, host.IP, version)
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("hosts %s still have the previous password, run rosman rotate status", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+func PrintRotationStatus() error {
+	statuses, err := mikrotik.GetRotationStatus()
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "USER\tHOST\tIP\tVERSION\tLATEST\tROTATED\tSTATUS")
+	behind := 0
+	for _, status := range statuses {
+		rotated := "-"
+		if status.Rotated > 0 {
+			rotated = time.Unix(status.Rotated, 0).Format("2006-01-02 15:04:05")
+		}
+		state := "current"
+		if !status.IsCurrent() {
+			state = "behind"
+			behind++
+		}
+		if status.Error != "" {
+			state = fmt.Sprintf("failed: %s", status.Error)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", status.Login, status.Host.Name, status.Host.IP, status.Version, status.Latest, rotated, state)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	if behind > 0 {
+		return errors.New(fmt.Sprintf("%d user passwords on hosts are behind the latest version", behind))
+	}
+	return nil
+}
+
+func CmdRotateSelf(args []string) error {
+	flags := flag.NewFlagSet("rotate-self", flag.ContinueOnError)
+	hostName := flags.String("host", "", "host name or ip")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	hosts, err := GetHosts(*hostName)
+	if err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		err = host.RotateSelf()
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s (%s): %s, remaining hosts are not touched", host.Name, host.IP, err))
+		}
+		fmt.Printf("%-20s %-15s rotated\n", host.Name, host.IP)
+	}
+	return nil
+}
+
+func CmdGrant(args []string) error {
+	usage := errors.New("usage: rosman grant list [--all] | add --user alias --host name|ip[,...] [--from time] --until time|period --reason text | revoke --id id")
+	if len(args) == 0 {
+		return usage
+	}
+	now := time.Now().Truncate(time.Second)
+	grants := mikrotik.GetGrants()
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("grant list", flag.ContinueOnError)
+		all := flags.Bool("all", false, "include expired and revoked grants")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tUSER\tHOSTS\tFROM\tUNTIL\tSTATUS\tREASON")
+		for _, grant := range grants {
+			status := grant.GetStatus(now)
+			if !*all && (status == mikrotik.GrantExpired || status == mikrotik.GrantRevoked) {
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", grant.ID, grant.User, strings.Join(grant.Hosts, ","),
+				grant.From.Format("2006-01-02 15:04"), grant.Until.Format("2006-01-02 15:04"), status, grant.Reason)
+		}
+		return writer.Flush()
+	case "add":
+		flags := flag.NewFlagSet("grant add", flag.ContinueOnError)
+		alias := flags.String("user", "", "user alias from users.json")
+		hosts := flags.String("host", "", "comma separated host names or ips, * for all hosts")
+		from := flags.String("from", "", "start (2006-01-02T15:04), now by default")
+		until := flags.String("until", "", "end (2006-01-02T15:04) or period after start (4h, 2d)")
+		reason := flags.String("reason", "", "reason of the grant")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *alias == "" || *hosts == "" || *until == "" || *reason == "" {
+			return usage
+		}
+		grant := &mikrotik.TGrant{ID: mikrotik.NewGrantID(), User: *alias, Hosts: strings.Split(*hosts, ","), Reason: *reason, Created: now}
+		grant.From, err = mikrotik.ParseGrantTime(*from, now)
+		if err != nil {
+			return err
+		}
+		grant.Until, err = mikrotik.ParseGrantTime(*until, grant.From)
+		if err != nil {
+			return err
+		}
+		err = mikrotik.SaveGrants(append(grants, grant))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("grant %s: %s on %s from %s until %s\n", grant.ID, grant.User, *hosts, grant.From.Format(time.RFC3339), grant.Until.Format(time.RFC3339))
+		return nil
+	case "revoke":
+		flags := flag.NewFlagSet("grant revoke", flag.ContinueOnError)
+		id := flags.String("id", "", "grant id")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		grant, err := grants.GetByID(*id)
+		if err != nil {
+			return err
+		}
+		if grant.Revoked != nil {
+			return errors.New(fmt.Sprintf("grant \"%s\" is already revoked", grant.ID))
+		}
+		grant.Revoked = &now
+		err = mikrotik.SaveGrants(grants)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("grant %s revoked, the user is removed by the next run with clean_users\n", grant.ID)
+		return nil
+	}
+	return usage
+}
+
+func CmdBreakglass(args []string) error {
+	usage := errors.New("usage: rosman breakglass reveal --host name|ip --reason text | status")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "status":
+		statuses, err := mikrotik.GetBreakglassStatus()
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "HOST\tIP\tVERSION\tLATEST\tREVEALED\tROTATE AT")
+		for _, status := range statuses {
+			revealed, rotateAt := "-", "-"
+			if status.Revealed != nil {
+				revealed = status.Revealed.Format("2006-01-02 15:04:05")
+				rotateAt = status.RotateAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%s\t%s\n", status.Host.Name, status.Host.IP, status.Version, status.Latest, revealed, rotateAt)
+		}
+		return writer.Flush()
+	case "reveal":
+		flags := flag.NewFlagSet("breakglass reveal", flag.ContinueOnError)
+		hostName := flags.String("host", "", "host name or ip")
+		reason := flags.String("reason", "", "reason written to the audit log")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *hostName == "" || *reason == "" {
+			return usage
+		}
+		host, err := mikrotik.Hosts.GetByNameOrIP(*hostName)
+		if err != nil {
+			return err
+		}
+		operator := os.Getenv("SUDO_USER")
+		if operator == "" {
+			operator = os.Getenv("USER")
+		}
+		password, rotateAt, err := host.RevealBreakglass(operator, *reason)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user:     %s\npassword: %s\nthe password is rotated after %s\n", mikrotik.Breakglass.Login, password, rotateAt.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+	return usage
+}
@@ -0,0 +1,421 @@
+package mikrotik
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type THostStatus struct {
+	Name     string        `json:"name"`
+	IP       string        `json:"ip"`
+	LastSeen int64         `json:"last_seen"`
+	Running  string        `json:"running"`
+	Jobs     []*TJobStatus `json:"jobs"`
+}
+
+type TJobStatus struct {
+	Name        string    `json:"name"`
+	Task        string    `json:"task"`
+	LastRun     int64     `json:"last_run"`
+	LastSuccess int64     `json:"last_success"`
+	LastError   string    `json:"last_error"`
+	Alerting    bool      `json:"alerting"`
+	NextRun     time.Time `json:"next_run"`
+}
+
+type THostPlan struct {
+	Name         string         `json:"name"`
+	IP           string         `json:"ip"`
+	Jobs         []*TJobPlan    `json:"jobs"`
+	Users        []*TUserPlan   `json:"users"`
+	UsersAllowed TListOfStrings `json:"users_allowed"`
+	Groups       TGroups        `json:"groups"`
+	Schedules    []*TSchedule   `json:"schedules"`
+}
+
+type TJobPlan struct {
+	Name     string         `json:"name"`
+	Task     string         `json:"task"`
+	Sequence TListOfStrings `json:"sequence"`
+	NextRuns []time.Time    `json:"next_runs"`
+}
+
+type TUserPlan struct {
+	Login   string         `json:"login"`
+	Group   string         `json:"group"`
+	Address string         `json:"address"`
+	Comment string         `json:"comment"`
+	Key     string         `json:"key"`
+	Keys    TListOfStrings `json:"keys"`
+}
+
+type TBackupFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+const planNextRuns = 5
+
+func (host *THost) GetStatus() *THostStatus {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	status := &THostStatus{Name: host.Name, IP: host.IP, LastSeen: host.LastSeen, Running: host.running}
+	for _, job := range host.Jobs {
+		status.Jobs = append(status.Jobs, &TJobStatus{
+			Name:        job.Name,
+			Task:        job.Task.Name,
+			LastRun:     job.LastRun,
+			LastSuccess: job.LastSuccess,
+			LastError:   job.LastError,
+			Alerting:    job.Alerting,
+			NextRun:     job.GetNextTime(),
+		})
+	}
+	return status
+}
+
+func (host *THost) GetPlan() *THostPlan {
+	users, groups, schedules := host.GetManaged()
+	plan := &THostPlan{
+		Name:         host.Name,
+		IP:           host.IP,
+		UsersAllowed: host.GetUsersAllowed(),
+		Groups:       groups,
+		Schedules:    schedules,
+	}
+	now := time.Now()
+	for _, job := range host.Jobs {
+		plan.Jobs = append(plan.Jobs, &TJobPlan{
+			Name:     job.Name,
+			Task:     job.Task.Name,
+			Sequence: job.Sequence,
+			NextRuns: job.Task.NextN(now, planNextRuns),
+		})
+	}
+	for _, user := range users {
+		address, err := host.ExpandAddress(user.Address)
+		if err != nil {
+			address = user.Address
+		}
+		plan.Users = append(plan.Users, &TUserPlan{
+			Login:   user.Login,
+			Group:   user.Group,
+			Address: address,
+			Comment: user.Comment,
+			Key:     user.Key,
+			Keys:    user.GetKeyFingerprints(),
+		})
+	}
+	return plan
+}
+
+func (host *THost) GetBackupFiles() ([]*TBackupFile, error) {
+	files := []*TBackupFile{}
+	dir, err := host.GetBackupDir()
+	if err != nil {
+		return files, err
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &TBackupFile{Path: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, err
+}
+
+func (host *THost) GetBackupFilePath(name string) (string, error) {
+	dir, err := host.GetBackupDir()
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("file \"%s\" is outside of backup directory", name))
+	}
+	return path, nil
+}
+
+func (host *THost) Trigger(job string, action string, options TRunOptions) error {
+	if job != "" {
+		hostJob, err := host.Jobs.GetByName(job)
+		if err != nil {
+			return err
+		}
+		if !hostJob.Trigger(options) {
+			host.Log().Info("job is already triggered", "job", job)
+		}
+		return nil
+	}
+	if action == "" {
+		return errors.New("either \"job\" or \"action\" is required")
+	}
+	if _, ok := Actions[action]; !ok {
+		if _, err := Profiles.GetByName(action); err != nil {
+			return errors.New(fmt.Sprintf("action \"%s\" does not exist", action))
+		}
+	}
+	go func() {
+		err := host.RunAction(action, options)
+		if err != nil {
+			host.Log().Error("action error", "action", action, "error", err)
+		}
+	}()
+	return nil
+}
+
+func NewAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/hosts", APIHosts)
+	mux.HandleFunc("/api/hosts/", APIHost)
+	mux.HandleFunc("/", DashboardHandler)
+	return APIAuth(mux)
+}
+
+// CheckOrigin rejects requests a browser sends on behalf of another site, a
+// page on any origin can post to the loopback listener. Clients other than
+// browsers send no Origin.
+func CheckOrigin(request *http.Request) error {
+	if request.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return errors.New("cross-site request")
+	}
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host != request.Host {
+		return errors.New(fmt.Sprintf("origin \"%s\" is not allowed", origin))
+	}
+	return nil
+}
+
+// CheckHost guards the unauthenticated listener against DNS rebinding: a page
+// of another site whose name resolves to the loopback address sends matching
+// Origin and Host, but not one of the names the listener is reached by.
+func CheckHost(request *http.Request) error {
+	name := request.Host
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.Trim(name, "[]")
+	if strings.EqualFold(name, "localhost") {
+		return nil
+	}
+	if ip := net.ParseIP(name); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	allowed := strings.Split(Params.GetValue("api_hosts"), ",")
+	if host, _, err := net.SplitHostPort(Params.GetValue("api_listen")); err == nil {
+		allowed = append(allowed, host)
+	}
+	for _, host := range allowed {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			continue
+		}
+		if strings.EqualFold(host, name) {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("host \"%s\" is not allowed, add it to param \"api_hosts\"", request.Host))
+}
+
+func APIAuth(handler http.Handler) http.Handler {
+	token := Params.GetValue("api_token")
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := CheckOrigin(request); err != nil {
+			WriteAPIError(writer, http.StatusForbidden, err)
+			return
+		}
+		if token == "" {
+			if err := CheckHost(request); err != nil {
+				WriteAPIError(writer, http.StatusForbidden, err)
+				return
+			}
+		}
+		if token == "" && GetRunOptions(request).ForceDeletions {
+			WriteAPIError(writer, http.StatusForbidden, errors.New("force_deletions requires param \"api_token\""))
+			return
+		}
+		if token != "" {
+			header := request.Header.Get("Authorization")
+			_, password, basic := request.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+token)) != 1 &&
+				(!basic || subtle.ConstantTimeCompare([]byte(password), []byte(token)) != 1) {
+				writer.Header().Set("WWW-Authenticate", `Basic realm="rosman"`)
+				WriteAPIError(writer, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+func WriteAPIJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(status)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		Log.Error("api error", "component", "api", "error", err)
+	}
+}
+
+func WriteAPIError(writer http.ResponseWriter, status int, err error) {
+	WriteAPIJSON(writer, status, map[string]string{"error": err.Error()})
+}
+
+func APIHosts(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteAPIError(writer, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var statuses []*THostStatus
+	for _, host := range Hosts {
+		statuses = append(statuses, host.GetStatus())
+	}
+	WriteAPIJSON(writer, http.StatusOK, statuses)
+}
+
+func APIHost(writer http.ResponseWriter, request *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/api/hosts/"), "/", 3)
+	host, err := Hosts.GetByNameOrIP(parts[0])
+	if err != nil {
+		WriteAPIError(writer, http.StatusNotFound, err)
+		return
+	}
+	var resource string
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+	method := http.MethodGet
+	if resource == "run" {
+		method = http.MethodPost
+	}
+	if request.Method != method {
+		WriteAPIError(writer, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	switch resource {
+	case "":
+		WriteAPIJSON(writer, http.StatusOK, host.GetStatus())
+	case "run":
+		err = host.Trigger(request.URL.Query().Get("job"), request.URL.Query().Get("action"), GetRunOptions(request))
+		if err != nil {
+			WriteAPIError(writer, http.StatusBadRequest, err)
+			return
+		}
+		WriteAPIJSON(writer, http.StatusAccepted, map[string]string{"status": "accepted"})
+	case "report":
+		run, err := ReadLastRun(THistoryFilter{Host: host.IP, Job: request.URL.Query().Get("job")})
+		if err != nil {
+			WriteAPIError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		if run == nil {
+			WriteAPIError(writer, http.StatusNotFound, errors.New("no runs recorded"))
+			return
+		}
+		WriteAPIJSON(writer, http.StatusOK, run)
+	case "plan":
+		WriteAPIJSON(writer, http.StatusOK, host.GetPlan())
+	case "drift":
+		// a check connects to the device and waits for a running job, so it
+		// runs in the background and GET returns the last one
+		if request.Method == http.MethodPost {
+			go func() {
+				_, err := host.Inspect()
+				if err != nil {
+					host.Log().Error("inspect error", "error", err)
+				}
+			}()
+			WriteAPIJSON(writer, http.StatusAccepted, map[string]string{"status": "accepted"})
+			return
+		}
+		drift, driftError := host.GetLastDrift()
+		if driftError != "" {
+			WriteAPIError(writer, http.StatusBadGateway, errors.New(driftError))
+			return
+		}
+		if drift == nil {
+			WriteAPIError(writer, http.StatusNotFound, errors.New("drift has not been checked, POST to check it"))
+			return
+		}
+		WriteAPIJSON(writer, http.StatusOK, drift)
+	case "backups":
+		if len(parts) > 2 && parts[2] != "" {
+			path, err := host.GetBackupFilePath(parts[2])
+			if err != nil {
+				WriteAPIError(writer, http.StatusBadRequest, err)
+				return
+			}
+			writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+			http.ServeFile(writer, request, path)
+			return
+		}
+		files, err := host.GetBackupFiles()
+		if err != nil {
+			WriteAPIError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		WriteAPIJSON(writer, http.StatusOK, files)
+	default:
+		WriteAPIError(writer, http.StatusNotFound, errors.New(fmt.Sprintf("resource \"%s\" does not exist", resource)))
+	}
+}
+
+func GetRunOptions(request *http.Request) TRunOptions {
+	force, _ := strconv.ParseBool(request.URL.Query().Get("force_deletions"))
+	return TRunOptions{ForceDeletions: force}
+}
+
+func StartAPIServer() {
+	listen := Params.GetValue("api_listen")
+	if listen == "" {
+		return
+	}
+	server := &http.Server{Addr: listen, Handler: NewAPIHandler(), ReadHeaderTimeout: 10 * time.Second}
+	Log.Info("api listener started", "component", "api", "listen", listen)
+	if Params.GetValue("api_token") == "" {
+		Log.Warn("api is not authenticated, force_deletions is refused until param \"api_token\" is set", "component", "api")
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil {
+			Log.Error("api error", "component", "api", "error", err)
+		}
+	}()
+}
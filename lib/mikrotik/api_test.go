@@ -0,0 +1,57 @@
+package mikrotik
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIAuth(t *testing.T) {
+	hosts := Hosts
+	Hosts = THosts{{Name: "router", IP: "10.0.0.1"}}
+	t.Cleanup(func() { Hosts = hosts })
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		path    string
+		host    string
+		headers map[string]string
+		status  int
+	}{
+		{"status without token", "", http.MethodGet, "/api/hosts", "127.0.0.1:9743", nil, http.StatusOK},
+		{"same origin", "", http.MethodGet, "/api/hosts", "localhost:9743", map[string]string{"Origin": "http://localhost:9743"}, http.StatusOK},
+		{"rebound name with matching origin", "", http.MethodGet, "/api/hosts", "evil.example:9743", map[string]string{"Origin": "http://evil.example:9743"}, http.StatusForbidden},
+		{"rebound name posting a run", "", http.MethodPost, "/api/hosts/router/run?action=none", "evil.example:9743", nil, http.StatusForbidden},
+		{"configured host name", "", http.MethodGet, "/api/hosts", "rosman.lan:9743", map[string]string{"Origin": "http://rosman.lan:9743"}, http.StatusOK},
+		{"cross origin", "", http.MethodPost, "/api/hosts/router/run?action=none", "127.0.0.1:9743", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"cross site fetch", "", http.MethodGet, "/api/hosts", "127.0.0.1:9743", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"force deletions without token", "", http.MethodPost, "/api/hosts/router/run?action=none&force_deletions=true", "127.0.0.1:9743", nil, http.StatusForbidden},
+		{"force deletions with token", "secret", http.MethodPost, "/api/hosts/router/run?action=none&force_deletions=true", "example.com", map[string]string{"Authorization": "Bearer secret"}, http.StatusBadRequest},
+		{"wrong token", "secret", http.MethodGet, "/api/hosts", "example.com", map[string]string{"Authorization": "Bearer other"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useParams(t, nil)
+			Params = append(Params, &TParam{Name: "api_token", Value: test.token}, &TParam{Name: "api_hosts", Value: "rosman.lan"})
+			server := httptest.NewServer(NewAPIHandler())
+			defer server.Close()
+			request, err := http.NewRequest(test.method, server.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Host = test.host
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = response.Body.Close()
+			if response.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.StatusCode)
+			}
+		})
+	}
+}
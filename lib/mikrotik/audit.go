@@ -0,0 +1,351 @@
+package mikrotik
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/routeros.v2"
+)
+
+type TAuditEntry struct {
+	Seq           uint64    `json:"seq"`
+	Time          time.Time `json:"time"`
+	Host          string    `json:"host"`
+	IP            string    `json:"ip"`
+	Job           string    `json:"job,omitempty"`
+	RunID         string    `json:"run_id,omitempty"`
+	ConfigVersion string    `json:"config_version"`
+	Command       string    `json:"command"`
+	Args          []string  `json:"args,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	PrevHash      string    `json:"prev_hash"`
+	Hash          string    `json:"hash"`
+}
+
+type TAuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size,omitempty"`
+}
+
+const auditMask = "***"
+
+var auditReadCommands = TListOfStrings{"print", "getall", "monitor", "listen"}
+
+var auditSecretArgs = TListOfStrings{"password", "passphrase", "secret", "private-key", "key"}
+
+var ConfigVersion string
+
+var auditMutex sync.Mutex
+
+func GetAuditPath() string {
+	return Params.GetValue("file_audit")
+}
+
+// LoadConfigVersion hashes main.json and every file in the config directory,
+// so that audit entries can be traced back to the configuration that caused them.
+func LoadConfigVersion(dirCfg string) error {
+	paths, err := filepath.Glob(filepath.Join(dirCfg, "*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	paths = append([]string{cfgMain}, paths...)
+	hash := sha256.New()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.Base(path), len(content))
+		hash.Write(content)
+	}
+	ConfigVersion = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+func IsMutatingCommand(command string) bool {
+	return !auditReadCommands.IsContain(command[strings.LastIndex(command, "/")+1:])
+}
+
+func SanitizeArgs(args []string) []string {
+	sanitized := make([]string, len(args))
+	for i, arg := range args {
+		sanitized[i] = arg
+		if !strings.HasPrefix(arg, "=") {
+			continue
+		}
+		parts := strings.SplitN(arg[1:], "=", 2)
+		if len(parts) == 2 && auditSecretArgs.IsContain(parts[0]) {
+			sanitized[i] = "=" + parts[0] + "=" + auditMask
+		}
+	}
+	return sanitized
+}
+
+// RunAPI retries the connection and read commands with the step_retry policy
+// of the running step. A failed change is not sent again, its outcome on the
+// device is unknown.
+func (host *THost) RunAPI(command string, args ...string) (*routeros.Reply, error) {
+	var connApi *routeros.Client
+	err := host.RetryStep("connect", func() error {
+		var err error
+		connApi, err = host.GetConnectionAPI()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !IsMutatingCommand(command) {
+		var reply *routeros.Reply
+		err = host.RetryStep(command, func() error {
+			connApi, err = host.GetConnectionAPI()
+			if err != nil {
+				return err
+			}
+			reply, err = connApi.Run(append([]string{command}, args...)...)
+			return err
+		})
+		return reply, err
+	}
+	reply, err := connApi.Run(append([]string{command}, args...)...)
+	host.RecordAudit(command, args, err)
+	return reply, err
+}
+
+func (host *THost) RecordAudit(command string, args []string, result error) {
+	entry := &TAuditEntry{
+		Time:          time.Now(),
+		Host:          host.Name,
+		IP:            host.IP,
+		ConfigVersion: ConfigVersion,
+		Command:       command,
+		Args:          SanitizeArgs(args),
+		Status:        StatusSuccess,
+	}
+	if host.run != nil {
+		entry.Job = host.run.Job
+		entry.RunID = host.run.ID
+	}
+	if result != nil {
+		entry.Status = StatusFailed
+		entry.Error = result.Error()
+	}
+	err := AppendAudit(entry)
+	if err != nil {
+		host.Log().Error("audit error", "command", command, "error", err)
+	}
+}
+
+func (entry *TAuditEntry) ComputeHash() (string, error) {
+	copied := *entry
+	copied.Hash = ""
+	content, err := json.Marshal(copied)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(entry.PrevHash), content...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AppendAudit links the entry to the last one in the file. The daemon and CLI
+// commands append to the same log, so the head is read again under a file
+// lock for every entry.
+func AppendAudit(entry *TAuditEntry) error {
+	path := GetAuditPath()
+	if path == "" {
+		return nil
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	head, err := ReadAuditHead(path)
+	if err != nil {
+		return err
+	}
+	entry.Seq = head.Seq + 1
+	entry.PrevHash = head.Hash
+	entry.Hash, err = entry.ComputeHash()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	errClose := file.Close()
+	if err != nil {
+		return err
+	}
+	if errClose != nil {
+		return errClose
+	}
+	content, err := json.Marshal(&TAuditHead{Seq: entry.Seq, Hash: entry.Hash, Size: info.Size()})
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".head.tmp", content, 0640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".head.tmp", path+".head")
+}
+
+// ReadAuditHead returns the sequence number and hash of the last entry. The
+// head file is preferred; entries written after it by a process that stopped
+// before updating it are read from the log. A log shorter than the head is
+// not followed, VerifyAudit reports it.
+func ReadAuditHead(path string) (*TAuditHead, error) {
+	head := &TAuditHead{}
+	content, err := ioutil.ReadFile(path + ".head")
+	if err == nil {
+		err = json.Unmarshal(content, head)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return head, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= head.Size {
+		return head, nil
+	}
+	err = scanAudit(path, head.Size, func(entry *TAuditEntry) error {
+		head.Seq, head.Hash = entry.Seq, entry.Hash
+		return nil
+	})
+	head.Size = info.Size()
+	return head, err
+}
+
+func ScanAudit(path string, fn func(entry *TAuditEntry) error) error {
+	return scanAudit(path, 0, fn)
+}
+
+func scanAudit(path string, offset int64, fn func(entry *TAuditEntry) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry TAuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return errors.New(fmt.Sprintf("line %d: %s", line, err))
+		}
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyAudit walks the whole chain and checks sequence numbers, links and
+// hashes of every entry, then compares the last entry with the head file to
+// detect records cut from the end.
+func VerifyAudit() (uint64, error) {
+	path := GetAuditPath()
+	if path == "" {
+		return 0, errors.New("param \"file_audit\" is not configured")
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+	last := &TAuditHead{}
+	err = ScanAudit(path, func(entry *TAuditEntry) error {
+		if entry.Seq != last.Seq+1 {
+			return errors.New(fmt.Sprintf("entry %d: expected sequence %d, records are missing", entry.Seq, last.Seq+1))
+		}
+		if entry.PrevHash != last.Hash {
+			return errors.New(fmt.Sprintf("entry %d: previous hash does not match entry %d", entry.Seq, last.Seq))
+		}
+		hash, err := entry.ComputeHash()
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return errors.New(fmt.Sprintf("entry %d: hash mismatch, record was modified", entry.Seq))
+		}
+		last.Seq, last.Hash = entry.Seq, entry.Hash
+		return nil
+	})
+	if err != nil {
+		return last.Seq, err
+	}
+	content, err := ioutil.ReadFile(path + ".head")
+	if errors.Is(err, os.ErrNotExist) {
+		if last.Seq > 0 {
+			return last.Seq, errors.New("head file is missing")
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return last.Seq, err
+	}
+	var head TAuditHead
+	err = json.Unmarshal(content, &head)
+	if err != nil {
+		return last.Seq, err
+	}
+	if head.Seq != last.Seq || head.Hash != last.Hash {
+		return last.Seq, errors.New(fmt.Sprintf("log ends at entry %d, head points to entry %d, records were removed from the end", last.Seq, head.Seq))
+	}
+	return last.Seq, nil
+}
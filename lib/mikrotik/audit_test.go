@@ -0,0 +1,151 @@
+package mikrotik
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useAudit(t *testing.T) string {
+	t.Helper()
+	dir := useParams(t, map[string]string{"file_audit": "audit.jsonl"})
+	return filepath.Join(dir, "audit.jsonl")
+}
+
+func writeAudit(t *testing.T, count int) {
+	t.Helper()
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	for i := 0; i < count; i++ {
+		var result error
+		if i%2 == 1 {
+			result = errors.New("failure")
+		}
+		host.RecordAudit("/user/set", []string{"=numbers=admin", "=password=secret"}, result)
+	}
+}
+
+func TestAuditVerify(t *testing.T) {
+	path := useAudit(t)
+	writeAudit(t, 5)
+	count, err := VerifyAudit()
+	if err != nil || count != 5 {
+		t.Fatalf("expected 5 valid entries, got %d: %v", count, err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Error("password is written to the audit log")
+	}
+}
+
+func TestAuditTamper(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(lines []string) []string
+		error  string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], "admin", "other", 1)
+			return lines
+		}, "hash mismatch"},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, "records are missing"},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, "records are missing"},
+		{"truncated", func(lines []string) []string {
+			return lines[:3]
+		}, "head"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := useAudit(t)
+			writeAudit(t, 5)
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := test.change(strings.Split(strings.TrimSpace(string(content)), "\n"))
+			err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0640)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = VerifyAudit()
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected error with %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+// TestAuditHelperProcess appends entries when started by TestAuditProcesses,
+// it does nothing in a normal test run.
+func TestAuditHelperProcess(t *testing.T) {
+	path := os.Getenv("ROSMAN_TEST_AUDIT")
+	if path == "" {
+		return
+	}
+	Params = TParams{{Name: "file_audit", Value: path}}
+	writeAudit(t, 50)
+}
+
+func TestAuditProcesses(t *testing.T) {
+	path := useAudit(t)
+	var commands []*exec.Cmd
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAuditHelperProcess$")
+		cmd.Env = append(os.Environ(), "ROSMAN_TEST_AUDIT="+path)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		commands = append(commands, cmd)
+	}
+	writeAudit(t, 50)
+	for _, cmd := range commands {
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := VerifyAudit()
+	if err != nil || count != 150 {
+		t.Errorf("expected a chain of 150 entries, got %d: %v", count, err)
+	}
+}
+
+func TestAuditStaleHead(t *testing.T) {
+	path := useAudit(t)
+	writeAudit(t, 2)
+	head, err := ioutil.ReadFile(path + ".head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, 1)
+	// the process stopped after writing the entry and before the head
+	if err = ioutil.WriteFile(path+".head", head, 0640); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, 1)
+	count, err := VerifyAudit()
+	if err != nil || count != 4 {
+		t.Errorf("expected a chain of 4 entries, got %d: %v", count, err)
+	}
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	args := SanitizeArgs([]string{"=name=bob", "=password=secret", "=private-key=abc", "?name=key", "=comment=password=x"})
+	expected := []string{"=name=bob", "=password=***", "=private-key=***", "?name=key", "=comment=password=x"}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+	if IsMutatingCommand("/user/print") || !IsMutatingCommand("/user/remove") {
+		t.Error("read commands are not told from mutating ones")
+	}
+}
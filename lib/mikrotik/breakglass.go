@@ -0,0 +1,205 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type TBreakglass struct {
+	Enabled     bool   `json:"enabled"`
+	Login       string `json:"login"`
+	Group       string `json:"group"`
+	Address     string `json:"address"`
+	Comment     string `json:"comment"`
+	RotateAfter int    `json:"rotate_after"`
+	Note        string `json:"note"`
+}
+
+type TBreakglassStatus struct {
+	Host     *THost
+	Latest   int
+	Version  int
+	Revealed *time.Time
+	RotateAt time.Time
+}
+
+var Breakglass TBreakglass
+
+func (breakglass *TBreakglass) Load() error {
+	if !breakglass.Enabled {
+		return nil
+	}
+	if breakglass.Login == "" || breakglass.Group == "" {
+		return errors.New("breakglass: login and group must be set")
+	}
+	if breakglass.RotateAfter < 0 {
+		return errors.New("breakglass: rotate_after must not be negative")
+	}
+	if user := Users.GetByLogin(breakglass.Login); user != nil {
+		return errors.New(fmt.Sprintf("breakglass: login \"%s\" is also user \"%s\" in users.json", breakglass.Login, user.Alias))
+	}
+	return nil
+}
+
+func (breakglass *TBreakglass) IsLogin(login string) bool {
+	return breakglass.Enabled && breakglass.Login == login
+}
+
+func BreakglassSecretName(ip string) string {
+	return "breakglass/" + ip
+}
+
+func (breakglass *TBreakglass) GetRotateAt(revealed time.Time) time.Time {
+	return revealed.Add(time.Duration(breakglass.RotateAfter) * time.Hour)
+}
+
+// GetBreakglassTarget returns the password the host should carry. It is
+// generated for the host on first use and again once a revealed password
+// has been known for rotate_after hours.
+func GetBreakglassTarget(host *THost) (string, int, error) {
+	rotationMutex.Lock()
+	defer rotationMutex.Unlock()
+	name := BreakglassSecretName(host.IP)
+	secrets, _, err := ListSecrets()
+	if err != nil {
+		return "", 0, err
+	}
+	versions := secrets[name]
+	if len(versions) == 0 || (versions[len(versions)-1].Revealed != nil && !time.Now().Before(Breakglass.GetRotateAt(*versions[len(versions)-1].Revealed))) {
+		generated := TUser{Login: Breakglass.Login}
+		err = generated.GeneratePassword()
+		if err != nil {
+			return "", 0, err
+		}
+		version, err := PutSecret(name, generated.Pass)
+		if err != nil {
+			return "", 0, err
+		}
+		host.Log().Info("break-glass password generated", "secret", name, "version", version)
+		return generated.Pass, version, nil
+	}
+	password, entry, err := GetSecret(name, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	return password, entry.Version, nil
+}
+
+// SyncBreakglass keeps the break-glass user on the host with its own password.
+func (host *THost) SyncBreakglass() error {
+	if !Breakglass.Enabled {
+		return nil
+	}
+	password, version, err := GetBreakglassTarget(host)
+	if errors.Is(err, ErrSecretsDisabled) {
+		return &TPermanentError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	users, err := host.GetUsers()
+	if err != nil {
+		return err
+	}
+	login := Breakglass.Login
+	if !TUsers(users).IsContain(login) {
+		host.Log().Info("adding break-glass user", "user", login)
+		address, err := host.ExpandAddress(Breakglass.Address)
+		if err != nil {
+			return err
+		}
+		_, err = host.RunAPI("/user/add", "=name="+login, "=password="+password, "=group="+Breakglass.Group, "=address="+address, "=comment="+Breakglass.Comment, "=disabled=no")
+		if err != nil {
+			return err
+		}
+		host.SetPasswordState(login, version, nil)
+		host.RecordCreated("user", login)
+		return nil
+	}
+	err = host.SyncUserAddress(TUsers(users).GetByLogin(login), Breakglass.Address)
+	if err != nil {
+		return err
+	}
+	if host.GetPasswordState(login).Version == version {
+		return nil
+	}
+	host.Log().Info("rotating break-glass password", "user", login, "version", version)
+	_, err = host.RunAPI("/user/set", "=numbers="+login, "=password="+password, "=group="+Breakglass.Group, "=disabled=no")
+	host.SetPasswordState(login, version, err)
+	if err != nil {
+		host.EmitRun(EventRotationFailed, fmt.Sprintf("break-glass password rotation to version %d failed: %s", version, err),
+			map[string]string{"user": login, "version": fmt.Sprint(version), "error": err.Error()})
+		return err
+	}
+	host.RecordRotated("user", login, fmt.Sprintf("version %d", version))
+	return nil
+}
+
+// RevealBreakglass returns the password applied on the host and marks it as
+// revealed, so that the next sync_breakglass after rotate_after replaces it.
+// Every reveal is written to the audit log with the operator and reason.
+func (host *THost) RevealBreakglass(operator string, reason string) (string, time.Time, error) {
+	if !Breakglass.Enabled {
+		return "", time.Time{}, errors.New("break-glass account is not enabled in breakglass.json")
+	}
+	name := BreakglassSecretName(host.IP)
+	version := host.GetPasswordState(Breakglass.Login).Version
+	password, entry, err := GetSecret(name, version)
+	args := []string{"=user=" + Breakglass.Login, "=operator=" + operator, "=reason=" + reason, "=name=" + name}
+	if err == nil {
+		args = append(args, fmt.Sprintf("=version=%d", entry.Version))
+		err = MarkSecretRevealed(name, entry.Version)
+	}
+	host.RecordAudit("/rosman/breakglass/reveal", args, err)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return password, Breakglass.GetRotateAt(time.Now()), nil
+}
+
+func GetBreakglassStatus() ([]*TBreakglassStatus, error) {
+	var statuses []*TBreakglassStatus
+	secrets, _, err := ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range Hosts {
+		status := &TBreakglassStatus{Host: host, Version: host.GetPasswordState(Breakglass.Login).Version}
+		if versions := secrets[BreakglassSecretName(host.IP)]; len(versions) > 0 {
+			latest := versions[len(versions)-1]
+			status.Latest = latest.Version
+			status.Revealed = latest.Revealed
+			if latest.Revealed != nil {
+				status.RotateAt = Breakglass.GetRotateAt(*latest.Revealed)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// StartBreakglass wakes the jobs running sync_breakglass once a revealed
+// password is due for rotation.
+func StartBreakglass() {
+	if !Breakglass.Enabled {
+		return
+	}
+	go func() {
+		woken := map[string]int{}
+		for {
+			statuses, err := GetBreakglassStatus()
+			if err != nil {
+				Log.Error("break-glass error", "error", err)
+			}
+			for _, status := range statuses {
+				if status.Revealed == nil || time.Now().Before(status.RotateAt) || woken[status.Host.IP] == status.Latest {
+					continue
+				}
+				woken[status.Host.IP] = status.Latest
+				status.Host.TriggerJobs(TListOfStrings{"sync_breakglass"}, "revealed break-glass password")
+			}
+			time.Sleep(grantCheckInterval)
+		}
+	}()
+}
@@ -0,0 +1,75 @@
+package mikrotik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakglassLoad(t *testing.T) {
+	saved := Users
+	t.Cleanup(func() { Users = saved })
+	Users = TUsers{{Login: "alice", Alias: "alice"}}
+	tests := []struct {
+		name       string
+		breakglass TBreakglass
+		fails      bool
+	}{
+		{"disabled", TBreakglass{}, false},
+		{"valid", TBreakglass{Enabled: true, Login: "emergency", Group: "full", RotateAfter: 24}, false},
+		{"no group", TBreakglass{Enabled: true, Login: "emergency"}, true},
+		{"negative rotate_after", TBreakglass{Enabled: true, Login: "emergency", Group: "full", RotateAfter: -1}, true},
+		{"login is also user", TBreakglass{Enabled: true, Login: "alice", Group: "full"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.breakglass.Load(); (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestBreakglassRotation(t *testing.T) {
+	useSecrets(t)
+	saved, savedHosts := Breakglass, Hosts
+	t.Cleanup(func() { Breakglass, Hosts = saved, savedHosts })
+	Breakglass = TBreakglass{Enabled: true, Login: "emergency", Group: "full", RotateAfter: 1}
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	Hosts = THosts{host}
+	if err := PasswordPolicy.Load(); err != nil {
+		t.Fatal(err)
+	}
+	password, version, err := GetBreakglassTarget(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password == "" || version != 1 {
+		t.Fatalf("expected a generated version 1, got %d", version)
+	}
+	if again, version, err := GetBreakglassTarget(host); err != nil || again != password || version != 1 {
+		t.Fatalf("unrevealed password is replaced: version %d %v", version, err)
+	}
+	host.SetPasswordState(Breakglass.Login, version, nil)
+	revealed, rotateAt, err := host.RevealBreakglass("admin", "console access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revealed != password || rotateAt.Sub(time.Now()) > time.Hour || rotateAt.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("unexpected reveal, rotation at %v", rotateAt)
+	}
+	if _, version, _ := GetBreakglassTarget(host); version != 1 {
+		t.Errorf("revealed password is replaced before rotate_after: version %d", version)
+	}
+	Breakglass.RotateAfter = 0
+	rotated, version, err := GetBreakglassTarget(host)
+	if err != nil || version != 2 || rotated == password {
+		t.Fatalf("revealed password is not replaced: version %d %v", version, err)
+	}
+	statuses, err := GetBreakglassStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Latest != 2 || statuses[0].Version != 1 || statuses[0].Revealed != nil {
+		t.Errorf("unexpected status %+v", statuses[0])
+	}
+}
@@ -0,0 +1,269 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TCron struct {
+	Expression string
+	minute     []bool
+	hour       []bool
+	dom        []bool
+	month      []bool
+	dow        []bool
+	domAny     bool
+	dowAny     bool
+}
+
+type tCronField struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronFields = []tCronField{
+	{min: 0, max: 59},
+	{min: 0, max: 23},
+	{min: 1, max: 31},
+	{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+const cronYearsLimit = 5
+
+func ParseCron(expression string) (*TCron, error) {
+	spec := strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errors.New(fmt.Sprintf("cron \"%s\": expected %d fields, got %d", expression, len(cronFields), len(fields)))
+	}
+	cron := &TCron{Expression: expression}
+	sets := make([][]bool, len(cronFields))
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cron \"%s\": %s", expression, err))
+		}
+		sets[i] = set
+	}
+	cron.minute, cron.hour, cron.dom, cron.month, cron.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	if cron.dow[7] {
+		cron.dow[0] = true
+	}
+	cron.domAny = fields[2] == "*" || fields[2] == "?"
+	cron.dowAny = fields[4] == "*" || fields[4] == "?"
+	return cron, nil
+}
+
+func (field tCronField) parse(spec string) ([]bool, error) {
+	set := make([]bool, field.max+1)
+	for _, part := range strings.Split(spec, ",") {
+		var err error
+		var low, high, step = field.min, field.max, 1
+		rangeSpec := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			rangeSpec = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, errors.New(fmt.Sprintf("invalid step in \"%s\"", part))
+			}
+		}
+		switch {
+		case rangeSpec == "*" || rangeSpec == "?":
+		case strings.Contains(rangeSpec, "-"):
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			low, err = field.value(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+			high, err = field.value(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			low, err = field.value(rangeSpec)
+			if err != nil {
+				return nil, err
+			}
+			if rangeSpec != part {
+				high = field.max
+			} else {
+				high = low
+			}
+		}
+		if low > high {
+			return nil, errors.New(fmt.Sprintf("invalid range in \"%s\"", part))
+		}
+		for i := low; i <= high; i += step {
+			set[i] = true
+		}
+	}
+	return set, nil
+}
+
+func (field tCronField) value(spec string) (int, error) {
+	if value, ok := field.names[strings.ToLower(spec)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(spec)
+	if err != nil || value < field.min || value > field.max {
+		return 0, errors.New(fmt.Sprintf("value \"%s\" out of range %d-%d", spec, field.min, field.max))
+	}
+	return value, nil
+}
+
+// Next returns the first fire time strictly after from. Wall-clock times
+// skipped by a DST transition fire at the end of the gap, wall-clock times
+// repeated by a DST transition fire once, at their first occurrence.
+func (cron *TCron) Next(from time.Time, loc *time.Location) time.Time {
+	wall := cronWall(from.In(loc)).Add(time.Minute)
+	for {
+		wall = cron.nextWall(wall)
+		if wall.IsZero() {
+			return time.Time{}
+		}
+		next := cronResolve(wall, loc)
+		if next.After(from) {
+			return next
+		}
+		wall = wall.Add(time.Minute)
+	}
+}
+
+func (cron *TCron) nextWall(t time.Time) time.Time {
+	yearLimit := t.Year() + cronYearsLimit
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for !cron.month[int(t.Month())] {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !cron.matchDay(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for !cron.hour[t.Hour()] {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for !cron.minute[t.Minute()] {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+func (cron *TCron) matchDay(t time.Time) bool {
+	dom := cron.dom[t.Day()]
+	dow := cron.dow[int(t.Weekday())]
+	if cron.domAny || cron.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func cronWall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func cronResolve(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+	if cronWall(t).Equal(wall) {
+		for cronWall(t.Add(-time.Hour)).Equal(wall) {
+			t = t.Add(-time.Hour)
+		}
+		return t
+	}
+	t = t.Add(-3 * time.Hour).Truncate(time.Minute)
+	for cronWall(t).Before(wall) {
+		t = t.Add(time.Minute)
+	}
+	return t
+}
+
+func (tasks TTasks) Load() error {
+	var err error
+	for _, task := range tasks {
+		task.location = time.Local
+		if task.TimeZone != "" {
+			task.location, err = time.LoadLocation(task.TimeZone)
+			if err != nil {
+				return errors.New(fmt.Sprintf("task \"%s\": %s", task.Name, err))
+			}
+		}
+		if task.Cron != "" {
+			task.cron, err = ParseCron(task.Cron)
+			if err != nil {
+				return errors.New(fmt.Sprintf("task \"%s\": %s", task.Name, err))
+			}
+			if task.Next(time.Now()).IsZero() {
+				return errors.New(fmt.Sprintf("task \"%s\": cron \"%s\" never fires", task.Name, task.Cron))
+			}
+		} else if task.Delay <= 0 {
+			return errors.New(fmt.Sprintf("task \"%s\": either \"cron\" or a positive \"delay\" is required", task.Name))
+		}
+	}
+	return nil
+}
+
+func (task *TTask) Location() *time.Location {
+	if task.location == nil {
+		return time.Local
+	}
+	return task.location
+}
+
+func (task *TTask) Next(from time.Time) time.Time {
+	if task.cron != nil {
+		return task.cron.Next(from, task.Location())
+	}
+	var now = from.Unix()
+	var start = task.Start
+	var delay = task.Delay
+	next := (int64((now-start)/delay)+1)*delay + start
+	return time.Unix(next, 0).In(task.Location())
+}
+
+func (task *TTask) NextN(from time.Time, count int) []time.Time {
+	var times []time.Time
+	for i := 0; i < count; i++ {
+		from = task.Next(from)
+		if from.IsZero() {
+			break
+		}
+		times = append(times, from)
+	}
+	return times
+}
@@ -0,0 +1,84 @@
+package mikrotik
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expression string
+		fails      bool
+	}{
+		{"*/5 * * * *", false},
+		{"0 3 * * mon-fri", false},
+		{"0 0 1 jan,jul *", false},
+		{"@daily", false},
+		{"0 0 * * 7", false},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"0 24 * * *", true},
+		{"0 0 0 * *", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"0 0 * * fun", true},
+	}
+	for _, test := range tests {
+		_, err := ParseCron(test.expression)
+		if (err != nil) != test.fails {
+			t.Errorf("%q: unexpected result: %v", test.expression, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		expression string
+		loc        *time.Location
+		from       string
+		next       string
+	}{
+		{"every five minutes", "*/5 * * * *", time.UTC, "2026-01-01T10:02:30Z", "2026-01-01T10:05:00Z"},
+		{"strictly after from", "0 3 * * *", time.UTC, "2026-01-01T03:00:00Z", "2026-01-02T03:00:00Z"},
+		{"weekday", "0 3 * * mon", time.UTC, "2026-01-01T00:00:00Z", "2026-01-05T03:00:00Z"},
+		{"sunday as 7", "0 0 * * 7", time.UTC, "2026-01-01T00:00:00Z", "2026-01-04T00:00:00Z"},
+		{"day of month or weekday", "0 0 13 * fri", time.UTC, "2026-01-01T00:00:00Z", "2026-01-02T00:00:00Z"},
+		{"month wrap", "0 0 1 * *", time.UTC, "2026-12-15T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"leap day", "0 0 29 2 *", time.UTC, "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"time zone", "0 3 * * *", berlin, "2026-01-01T00:00:00Z", "2026-01-01T02:00:00Z"},
+		{"skipped time fires at the end of the gap", "30 2 * * *", berlin, "2026-03-28T12:00:00Z", "2026-03-29T01:00:00Z"},
+		{"repeated time fires once", "30 2 * * *", berlin, "2026-10-24T12:00:00Z", "2026-10-25T00:30:00Z"},
+		{"repeated time is not fired again", "30 2 * * *", berlin, "2026-10-25T00:30:00Z", "2026-10-26T01:30:00Z"},
+		{"hourly over the repeated hour", "0 * * * *", berlin, "2026-10-25T00:00:00Z", "2026-10-25T02:00:00Z"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := ParseCron(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, _ := time.Parse(time.RFC3339, test.from)
+			expected, _ := time.Parse(time.RFC3339, test.next)
+			next := cron.Next(from, test.loc)
+			if !next.Equal(expected) {
+				t.Errorf("expected %s, got %s", expected.UTC(), next.UTC())
+			}
+		})
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	cron, err := ParseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := cron.Next(time.Now(), time.UTC); !next.IsZero() {
+		t.Errorf("expected no fire time, got %s", next)
+	}
+}
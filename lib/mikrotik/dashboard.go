@@ -0,0 +1,158 @@
+package mikrotik
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type TDashboardPage struct {
+	Title      string
+	CSRF       string
+	Now        time.Time
+	Hosts      []*THostStatus
+	Status     *THostStatus
+	Actions    TListOfStrings
+	Drift      *TDrift
+	DriftError string
+	Report     *TRun
+	Backups    []*TBackupFile
+}
+
+// dashboardCSRF is sent with every form and checked on every POST, it lives
+// as long as the process.
+var dashboardCSRF = NewCSRFToken()
+
+//go:embed web/*.html
+var dashboardFiles embed.FS
+
+var dashboardTemplates = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"formatUnix":   FormatUnix,
+	"formatTime":   FormatTime,
+	"reachability": (*THostStatus).Reachability,
+	"jobResult":    (*TJobStatus).Result,
+}).ParseFS(dashboardFiles, "web/*.html"))
+
+func FormatUnix(unix int64) string {
+	if unix == 0 {
+		return "never"
+	}
+	return FormatTime(time.Unix(unix, 0))
+}
+
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+func (status *THostStatus) Reachability() string {
+	for _, job := range status.Jobs {
+		if job.Alerting {
+			return "alert"
+		}
+	}
+	for _, job := range status.Jobs {
+		if job.LastError != "" && ClassifyError(errors.New(job.LastError)) == ErrorTransient {
+			return "unreachable"
+		}
+	}
+	if status.LastSeen == 0 {
+		return "never"
+	}
+	return "ok"
+}
+
+func (status *TJobStatus) Result() string {
+	switch {
+	case status.LastRun == 0:
+		return "never"
+	case status.LastError != "":
+		return StatusFailed
+	}
+	return StatusSuccess
+}
+
+func NewCSRFToken() string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+func CheckCSRF(request *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(request.PostFormValue("csrf")), []byte(dashboardCSRF)) == 1
+}
+
+func DashboardHandler(writer http.ResponseWriter, request *http.Request) {
+	var err error
+	page := &TDashboardPage{Now: time.Now(), CSRF: dashboardCSRF}
+	if request.URL.Path == "/" {
+		for _, host := range Hosts {
+			page.Hosts = append(page.Hosts, host.GetStatus())
+		}
+		RenderDashboard(writer, "overview", page)
+		return
+	}
+	if !strings.HasPrefix(request.URL.Path, "/hosts/") {
+		http.NotFound(writer, request)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/hosts/"), "/", 2)
+	host, err := Hosts.GetByNameOrIP(parts[0])
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(parts) > 1 && (parts[1] == "run" || parts[1] == "inspect") {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !CheckCSRF(request) {
+			http.Error(writer, "invalid form token, reload the page", http.StatusForbidden)
+			return
+		}
+		if parts[1] == "inspect" {
+			go func() {
+				_, err := host.Inspect()
+				if err != nil {
+					host.Log().Error("inspect error", "error", err)
+				}
+			}()
+		} else {
+			err = host.Trigger(request.URL.Query().Get("job"), request.URL.Query().Get("action"), GetRunOptions(request))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		http.Redirect(writer, request, "/hosts/"+host.IP, http.StatusSeeOther)
+		return
+	}
+	page.Title = host.Name
+	page.Status = host.GetStatus()
+	for action := range Actions {
+		page.Actions = append(page.Actions, action)
+	}
+	sort.Strings(page.Actions)
+	page.Drift, page.DriftError = host.GetLastDrift()
+	page.Report, _ = ReadLastRun(THistoryFilter{Host: host.IP})
+	page.Backups, _ = host.GetBackupFiles()
+	RenderDashboard(writer, "host", page)
+}
+
+func RenderDashboard(writer http.ResponseWriter, name string, page *TDashboardPage) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplates.ExecuteTemplate(writer, name, page)
+	if err != nil {
+		Log.Error("dashboard error", "component", "dashboard", "error", err)
+	}
+}
@@ -0,0 +1,67 @@
+package mikrotik
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	hosts := Hosts
+	host := &THost{Name: "router", IP: "192.0.2.1", PortAPI: 8728}
+	Hosts = THosts{host}
+	t.Cleanup(func() { Hosts = hosts })
+	useParams(t, map[string]string{"dir_backup": "backup"})
+	server := httptest.NewServer(NewAPIHandler())
+	defer server.Close()
+
+	start := time.Now()
+	response, err := http.Get(server.URL + "/hosts/router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if time.Since(start) > 2*time.Second || !strings.Contains(string(body), "Not checked since start") {
+		t.Errorf("host page connects to the device or misses the last check:\n%s", body)
+	}
+	if !strings.Contains(string(body), `name="csrf" value="`+dashboardCSRF+`"`) {
+		t.Error("forms carry no token")
+	}
+
+	host.drift = &TDrift{Time: time.Now(), Users: TDriftItems{{Name: "intruder", Present: true, Status: DriftUnknown}}}
+	response, err = http.Get(server.URL + "/hosts/router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if !strings.Contains(string(body), "intruder") {
+		t.Error("last check is not shown")
+	}
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"without token", url.Values{}, http.StatusForbidden},
+		{"wrong token", url.Values{"csrf": {"wrong"}}, http.StatusForbidden},
+		{"with token", url.Values{"csrf": {dashboardCSRF}}, http.StatusBadRequest},
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, test := range tests {
+		response, err := client.PostForm(server.URL+"/hosts/router/run?action=none", test.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package mikrotik
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type TDigestConfig struct {
+	Enabled    bool           `json:"enabled"`
+	TaskName   string         `json:"task_name"`
+	Recipients TListOfStrings `json:"recipients"`
+	Subject    string         `json:"subject"`
+	Template   string         `json:"template"`
+	SendEmpty  bool           `json:"send_empty"`
+	task       *TTask
+	subject    *template.Template
+	body       *template.Template
+}
+
+type TDigest struct {
+	From    time.Time
+	To      time.Time
+	Runs    int
+	Failed  int
+	Hosts   []*THostDigest
+	Failing []*THostDigest
+}
+
+type THostDigest struct {
+	Name        string
+	IP          string
+	Runs        int
+	Failed      int
+	LastStatus  string
+	LastError   string
+	Failures    TRuns
+	Changes     []*TDigestChange
+	Backups     int
+	BackupBytes int64
+}
+
+type TDigestChange struct {
+	Kind   string
+	Action string
+	Names  TListOfStrings
+}
+
+const (
+	DigestAdded    = "added"
+	DigestRemoved  = "removed"
+	DigestReplaced = "replaced"
+	DigestDisabled = "disabled"
+	DigestUpdated  = "updated"
+	DigestRotated  = "rotated"
+)
+
+const digestMaxFailures = 5
+
+const DefaultDigestSubject = `[rosman] digest {{.To.Format "2006-01-02"}}: {{.Runs}} runs, {{.Failed}} failed, {{len .Hosts}} hosts`
+
+const DefaultDigestTemplate = `rosman digest from {{.From.Format "2006-01-02 15:04 MST"}} to {{.To.Format "2006-01-02 15:04 MST"}}
+
+Runs: {{.Runs}}, failed: {{.Failed}}, hosts: {{len .Hosts}}
+{{- if .Failing}}
+
+Failing hosts:
+{{- range .Failing}}
+  {{.Name}} ({{.IP}}): {{.LastError}}{{end}}{{end}}
+{{range .Hosts}}
+{{.Name}} ({{.IP}})
+  runs: {{.Runs}}, failed: {{.Failed}}
+{{- range .Changes}}
+  {{.Kind}}s {{.Action}}: {{join .Names ", "}}{{end}}
+{{- if .Backups}}
+  backups stored: {{.Backups}} files, {{.BackupBytes}} bytes{{end}}
+{{- range .Failures}}
+  failed {{.Start.Format "2006-01-02 15:04"}} job {{.Job}}: {{.Error}}{{end}}
+{{end}}`
+
+var digestKinds = TListOfStrings{"user", "group", "schedule", "ssh_key"}
+
+func (config *TDigestConfig) Load() error {
+	if config == nil || !config.Enabled {
+		return nil
+	}
+	var err error
+	config.task, err = Tasks.GetByName(config.TaskName)
+	if err != nil {
+		return errors.New(fmt.Sprintf("smtp digest: %s", err))
+	}
+	return config.ParseTemplates()
+}
+
+func (config *TDigestConfig) ParseTemplates() error {
+	var err error
+	funcs := template.FuncMap{"join": func(names TListOfStrings, sep string) string { return strings.Join(names, sep) }}
+	subject := config.Subject
+	if subject == "" {
+		subject = DefaultDigestSubject
+	}
+	config.subject, err = template.New("digest_subject").Funcs(funcs).Parse(subject)
+	if err != nil {
+		return errors.New(fmt.Sprintf("smtp digest: subject: %s", err))
+	}
+	body := config.Template
+	if body == "" {
+		body = DefaultDigestTemplate
+	}
+	config.body, err = template.New("digest").Funcs(funcs).Parse(body)
+	if err != nil {
+		return errors.New(fmt.Sprintf("smtp digest: template: %s", err))
+	}
+	return nil
+}
+
+func BuildDigest(from time.Time, to time.Time) (*TDigest, error) {
+	runs, err := ReadHistory(THistoryFilter{Since: from})
+	if err != nil {
+		return nil, err
+	}
+	digest := &TDigest{From: from, To: to}
+	hosts := map[string]*THostDigest{}
+	var created, deleted, disabled = map[string]TListOfStrings{}, map[string]TListOfStrings{}, map[string]TListOfStrings{}
+	var updated, rotated = map[string]TListOfStrings{}, map[string]TListOfStrings{}
+	for _, run := range runs {
+		if !run.Start.Before(to) {
+			continue
+		}
+		host, ok := hosts[run.IP]
+		if !ok {
+			host = &THostDigest{Name: run.Host, IP: run.IP}
+			hosts[run.IP] = host
+			digest.Hosts = append(digest.Hosts, host)
+		}
+		digest.Runs++
+		host.Runs++
+		host.LastStatus = run.Status
+		host.LastError = run.Error
+		if run.Status == StatusFailed {
+			digest.Failed++
+			host.Failed++
+			host.Failures = append(host.Failures, run)
+		}
+		for _, step := range run.Steps {
+			for _, object := range step.Created {
+				key := run.IP + "\xff" + object.Kind
+				created[key] = appendUnique(created[key], object.Name)
+			}
+			for _, object := range step.Deleted {
+				key := run.IP + "\xff" + object.Kind
+				deleted[key] = appendUnique(deleted[key], object.Name)
+			}
+			for _, object := range step.Disabled {
+				key := run.IP + "\xff" + object.Kind
+				disabled[key] = appendUnique(disabled[key], object.Name)
+			}
+			for _, object := range step.Updated {
+				key := run.IP + "\xff" + object.Kind
+				updated[key] = appendUnique(updated[key], object.Name)
+			}
+			for _, object := range step.Rotated {
+				key := run.IP + "\xff" + object.Kind
+				rotated[key] = appendUnique(rotated[key], object.Name)
+			}
+			for _, file := range step.Downloaded {
+				host.Backups++
+				host.BackupBytes += file.Size
+			}
+		}
+	}
+	for _, host := range digest.Hosts {
+		if len(host.Failures) > digestMaxFailures {
+			host.Failures = host.Failures[len(host.Failures)-digestMaxFailures:]
+		}
+		if host.LastStatus == StatusFailed {
+			digest.Failing = append(digest.Failing, host)
+		}
+		for _, kind := range digestKinds {
+			key := host.IP + "\xff" + kind
+			var added, removed, replaced TListOfStrings
+			for _, name := range created[key] {
+				if deleted[key].IsContain(name) {
+					replaced = append(replaced, name)
+				} else {
+					added = append(added, name)
+				}
+			}
+			for _, name := range deleted[key] {
+				if !created[key].IsContain(name) {
+					removed = append(removed, name)
+				}
+			}
+			host.addChange(kind, DigestAdded, added)
+			host.addChange(kind, DigestRemoved, removed)
+			host.addChange(kind, DigestReplaced, replaced)
+			host.addChange(kind, DigestDisabled, disabled[key])
+			host.addChange(kind, DigestUpdated, updated[key])
+			host.addChange(kind, DigestRotated, rotated[key])
+		}
+	}
+	sort.SliceStable(digest.Hosts, func(i, j int) bool { return digest.Hosts[i].Name < digest.Hosts[j].Name })
+	return digest, nil
+}
+
+func appendUnique(list TListOfStrings, value string) TListOfStrings {
+	if list.IsContain(value) {
+		return list
+	}
+	return append(list, value)
+}
+
+func (host *THostDigest) addChange(kind string, action string, names TListOfStrings) {
+	if len(names) == 0 {
+		return
+	}
+	host.Changes = append(host.Changes, &TDigestChange{Kind: kind, Action: action, Names: names})
+}
+
+func (config *TDigestConfig) Render(digest *TDigest) (string, string, error) {
+	var subject, body bytes.Buffer
+	err := config.subject.Execute(&subject, digest)
+	if err != nil {
+		return "", "", err
+	}
+	err = config.body.Execute(&body, digest)
+	return subject.String(), body.String(), err
+}
+
+func SendDigest(from time.Time, to time.Time) error {
+	config := SMTP.Digest
+	if !SMTP.Enabled || config == nil || !config.Enabled {
+		return errors.New("smtp digest is not enabled")
+	}
+	digest, err := BuildDigest(from, to)
+	if err != nil {
+		return err
+	}
+	if digest.Runs == 0 && !config.SendEmpty {
+		Log.Info("digest skipped, no runs", "component", "smtp")
+		return nil
+	}
+	subject, body, err := config.Render(digest)
+	if err != nil {
+		return err
+	}
+	recipients := config.Recipients
+	if len(recipients) == 0 {
+		recipients = SMTP.Recipients
+	}
+	return SMTP.Send(recipients, subject, body)
+}
+
+func StartDigest() {
+	config := SMTP.Digest
+	if !SMTP.Enabled || config == nil || !config.Enabled {
+		return
+	}
+	go func() {
+		for {
+			now := time.Now()
+			next := config.task.Next(now)
+			Log.Info("next digest", "component", "smtp", "time", next)
+			time.Sleep(next.Sub(now))
+			to := time.Now()
+			from := GetDigestLastSent()
+			if from.IsZero() {
+				from = to.Add(-24 * time.Hour)
+			}
+			err := SendDigest(from, to)
+			if err != nil {
+				Log.Error("digest error", "component", "smtp", "error", err)
+				continue
+			}
+			SetDigestLastSent(to)
+		}
+	}()
+}
+
+func GetDigestLastSent() time.Time {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	if digestLastSent == 0 {
+		return time.Time{}
+	}
+	return time.Unix(digestLastSent, 0)
+}
+
+func SetDigestLastSent(sent time.Time) {
+	stateMutex.Lock()
+	digestLastSent = sent.Unix()
+	stateMutex.Unlock()
+	err := SaveState()
+	if err != nil {
+		Log.Error("state error", "error", err)
+	}
+}
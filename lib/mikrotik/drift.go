@@ -0,0 +1,140 @@
+package mikrotik
+
+import "time"
+
+type TDrift struct {
+	Time      time.Time   `json:"time"`
+	Users     TDriftItems `json:"users"`
+	Groups    TDriftItems `json:"groups"`
+	Schedules TDriftItems `json:"schedules"`
+}
+
+type TDriftItems []*TDriftItem
+type TDriftItem struct {
+	Name    string `json:"name"`
+	Managed bool   `json:"managed"`
+	Present bool   `json:"present"`
+	Status  string `json:"status"`
+}
+
+const (
+	DriftOK      = "ok"
+	DriftAllowed = "allowed"
+	DriftMissing = "missing"
+	DriftUnknown = "unknown"
+)
+
+// GetDrift compares the device with the configuration. The result is kept
+// for the dashboard, which does not connect to devices on page views.
+func (host *THost) GetDrift() (*TDrift, error) {
+	drift, err := host.compareDrift()
+	stateMutex.Lock()
+	host.drift, host.driftError = drift, ""
+	if err != nil {
+		host.drift, host.driftError = nil, err.Error()
+	}
+	stateMutex.Unlock()
+	return drift, err
+}
+
+func (host *THost) GetLastDrift() (*TDrift, string) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return host.drift, host.driftError
+}
+
+func (host *THost) compareDrift() (*TDrift, error) {
+	drift := &TDrift{Time: time.Now()}
+	usersAllowed := host.GetUsersAllowed()
+	users, err := host.GetUsers()
+	if err != nil {
+		return drift, err
+	}
+	for _, user := range users {
+		item := &TDriftItem{Name: user.Login, Present: true, Managed: host.Users.IsContain(user.Login)}
+		switch {
+		case item.Managed:
+			item.Status = DriftOK
+		case usersAllowed.IsContain(user.Login):
+			item.Status = DriftAllowed
+		default:
+			item.Status = DriftUnknown
+		}
+		drift.Users = append(drift.Users, item)
+	}
+	for _, user := range host.Users {
+		if !TUsers(users).IsContain(user.Login) {
+			drift.Users = append(drift.Users, &TDriftItem{Name: user.Login, Managed: true, Status: DriftMissing})
+		}
+	}
+	groups, err := host.GetGroups()
+	if err != nil {
+		return drift, err
+	}
+	for _, group := range groups {
+		item := &TDriftItem{Name: group.Name, Present: true, Managed: host.Groups.IsContain(group.Name), Status: DriftOK}
+		if !item.Managed {
+			item.Status = DriftUnknown
+		}
+		drift.Groups = append(drift.Groups, item)
+	}
+	for _, group := range host.Groups {
+		if !groups.IsContain(group.Name) {
+			drift.Groups = append(drift.Groups, &TDriftItem{Name: group.Name, Managed: true, Status: DriftMissing})
+		}
+	}
+	schedules, err := host.GetSchedules()
+	if err != nil {
+		return drift, err
+	}
+	for _, schedule := range schedules {
+		item := &TDriftItem{Name: schedule.Name, Present: true, Managed: host.Schedules.IsContain(schedule.Name), Status: DriftOK}
+		if !item.Managed {
+			item.Status = DriftUnknown
+		}
+		drift.Schedules = append(drift.Schedules, item)
+	}
+	schedulesInside := TSchedules(schedules)
+	for _, schedule := range host.Schedules {
+		if !schedulesInside.IsContain(schedule.Name) {
+			drift.Schedules = append(drift.Schedules, &TDriftItem{Name: schedule.Name, Managed: true, Status: DriftMissing})
+		}
+	}
+	return drift, nil
+}
+
+func (items TDriftItems) CountChanges() int {
+	var count int
+	for _, item := range items {
+		if item.Status == DriftMissing || item.Status == DriftUnknown {
+			count++
+		}
+	}
+	return count
+}
+
+func (drift *TDrift) CountChanges() int {
+	return drift.Users.CountChanges() + drift.Groups.CountChanges() + drift.Schedules.CountChanges()
+}
+
+func (host *THost) Audit() error {
+	drift, err := host.GetDrift()
+	if err != nil {
+		return err
+	}
+	for kind, items := range map[string]TDriftItems{"user": drift.Users, "group": drift.Groups, "schedule": drift.Schedules} {
+		for _, item := range items {
+			if item.Status == DriftMissing || item.Status == DriftUnknown {
+				host.Log().Warn("audit: "+item.Status+" "+kind, kind, item.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (host *THost) Inspect() (*TDrift, error) {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	defer host.Disconnect()
+	return host.GetDrift()
+}
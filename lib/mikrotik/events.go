@@ -0,0 +1,102 @@
+package mikrotik
+
+import (
+	"sync"
+	"time"
+)
+
+type TEvent struct {
+	Type    string            `json:"type"`
+	Time    int64             `json:"time"`
+	Host    string            `json:"host"`
+	IP      string            `json:"ip"`
+	Job     string            `json:"job"`
+	RunID   string            `json:"run_id"`
+	Message string            `json:"message"`
+	Data    map[string]string `json:"data"`
+}
+
+type TListener func(event TEvent)
+
+const (
+	EventAlert           = "alert"
+	EventRecovered       = "recovered"
+	EventHostUnreachable = "host_unreachable"
+	EventHostReachable   = "host_reachable"
+	EventRunFailed       = "run_failed"
+	EventObjectRemoved   = "object_removed"
+	EventDriftCorrected  = "drift_corrected"
+	EventBackupStored    = "backup_stored"
+	EventObjectDisabled  = "object_disabled"
+	EventUnknownUser     = "unknown_user"
+	EventRotationFailed  = "rotation_failed"
+)
+
+var listeners []TListener
+var listenersMutex sync.RWMutex
+
+// deliveries tracks notifications sent in the background, CLI commands wait
+// for them before exiting.
+var deliveries sync.WaitGroup
+
+func AddListener(listener TListener) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	listeners = append(listeners, listener)
+}
+
+func Emit(event TEvent) {
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	listenersMutex.RLock()
+	defer listenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+func (host *THost) Emit(eventType string, job *TJob, message string, data map[string]string) {
+	event := TEvent{Type: eventType, Host: host.Name, IP: host.IP, Message: message, Data: data}
+	logger := host.Log()
+	if job != nil {
+		event.Job = job.Name
+		logger = logger.With("job", job.Name)
+	}
+	logger.Info(message, "event", eventType)
+	Emit(event)
+}
+
+func (host *THost) EmitRun(eventType string, message string, data map[string]string) {
+	event := TEvent{Type: eventType, Host: host.Name, IP: host.IP, Message: message, Data: data}
+	if host.run != nil {
+		event.Job = host.run.Job
+		event.RunID = host.run.ID
+	}
+	host.Log().Info(message, "event", eventType)
+	Emit(event)
+}
+
+func Deliver(deliver func()) {
+	deliveries.Add(1)
+	go func() {
+		defer deliveries.Done()
+		deliver()
+	}()
+}
+
+// WaitDeliveries waits for pending notifications up to timeout and reports
+// whether all of them are done.
+func WaitDeliveries(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,285 @@
+package mikrotik
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TGrants []*TGrant
+type TGrant struct {
+	ID      string         `json:"id"`
+	User    string         `json:"user"`
+	Hosts   TListOfStrings `json:"hosts"`
+	From    time.Time      `json:"from"`
+	Until   time.Time      `json:"until"`
+	Reason  string         `json:"reason"`
+	Created time.Time      `json:"created"`
+	Revoked *time.Time     `json:"revoked,omitempty"`
+	Note    string         `json:"note,omitempty"`
+}
+
+const (
+	GrantPending = "pending"
+	GrantActive  = "active"
+	GrantExpired = "expired"
+	GrantRevoked = "revoked"
+)
+
+const grantCheckInterval = time.Minute
+
+var Grants TGrants
+var grantsMutex sync.RWMutex
+var grantsModified time.Time
+var grantsChecked int64
+
+func GetGrantsPath() string {
+	return Params.GetValue("dir_mikrotik-config") + "grants.json"
+}
+
+func (grants TGrants) Load() error {
+	ids := map[string]bool{}
+	for _, grant := range grants {
+		if grant.ID == "" || ids[grant.ID] {
+			return errors.New(fmt.Sprintf("grant id \"%s\" is empty or not unique", grant.ID))
+		}
+		ids[grant.ID] = true
+		if Users.GetByAlias(grant.User) == nil {
+			return errors.New(fmt.Sprintf("grant \"%s\": user \"%s\" does not exist", grant.ID, grant.User))
+		}
+		if len(grant.Hosts) == 0 {
+			return errors.New(fmt.Sprintf("grant \"%s\": hosts are empty", grant.ID))
+		}
+		for _, name := range grant.Hosts {
+			if name == "*" {
+				continue
+			}
+			if _, err := Hosts.GetByNameOrIP(name); err != nil {
+				return errors.New(fmt.Sprintf("grant \"%s\": host \"%s\" does not exist", grant.ID, name))
+			}
+		}
+		if !grant.Until.After(grant.From) {
+			return errors.New(fmt.Sprintf("grant \"%s\": until must be after from", grant.ID))
+		}
+	}
+	return nil
+}
+
+// LoadGrants reads grants.json, a missing file means no grants. The file is
+// written by rosman grant while the service runs, so it is read again
+// whenever it changes.
+func LoadGrants() error {
+	path := GetGrantsPath()
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	grantsMutex.RLock()
+	unchanged := info.ModTime().Equal(grantsModified)
+	grantsMutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	var grants TGrants
+	err = LoadJSON(&grants, path)
+	if err != nil {
+		return err
+	}
+	err = grants.Load()
+	if err != nil {
+		return err
+	}
+	grantsMutex.Lock()
+	Grants = grants
+	grantsModified = info.ModTime()
+	grantsMutex.Unlock()
+	return nil
+}
+
+func GetGrants() TGrants {
+	grantsMutex.RLock()
+	defer grantsMutex.RUnlock()
+	return append(TGrants{}, Grants...)
+}
+
+func SaveGrants(grants TGrants) error {
+	err := grants.Load()
+	if err != nil {
+		return err
+	}
+	return SaveJSON(grants, GetGrantsPath())
+}
+
+func NewGrantID() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return time.Now().Format("20060102") + "-" + hex.EncodeToString(random)
+}
+
+// ParseGrantTime accepts a date as in ParseSince or a period (4h, 2d, 1w)
+// counted forward from base.
+func ParseGrantTime(value string, base time.Time) (time.Time, error) {
+	if value == "" {
+		return base, nil
+	}
+	parsed, err := ParseSince(value, base)
+	if err != nil {
+		return parsed, err
+	}
+	if _, err = time.ParseDuration(value); err == nil || strings.HasSuffix(value, "d") || strings.HasSuffix(value, "w") {
+		return base.Add(base.Sub(parsed)), nil
+	}
+	return parsed, nil
+}
+
+func (grants TGrants) GetByID(id string) (*TGrant, error) {
+	for _, grant := range grants {
+		if grant.ID == id {
+			return grant, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("grant \"%s\" does not exist", id))
+}
+
+func (grant *TGrant) GetStatus(now time.Time) string {
+	switch {
+	case grant.Revoked != nil:
+		return GrantRevoked
+	case now.Before(grant.From):
+		return GrantPending
+	case now.Before(grant.Until):
+		return GrantActive
+	}
+	return GrantExpired
+}
+
+func (grant *TGrant) MatchHost(host *THost) bool {
+	return grant.Hosts.IsContain("*") || grant.Hosts.IsContain(host.Name) || grant.Hosts.IsContain(host.IP)
+}
+
+// GetConfiguredUsers returns the users of users_aliases and the users of
+// grants that are active at the moment.
+func (host *THost) GetConfiguredUsers(now time.Time) TUsers {
+	users := TUsers(Users.FilterByAliases(host.UsersAliases))
+	for _, grant := range GetGrants() {
+		if !grant.MatchHost(host) || grant.GetStatus(now) != GrantActive {
+			continue
+		}
+		user := Users.GetByAlias(grant.User)
+		if !users.IsContain(user.Login) {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// GetEndedGrant returns an expired or revoked grant of a user that is not
+// configured any more, such a user is removed regardless of unknown_users.
+func (host *THost) GetEndedGrant(login string, now time.Time) *TGrant {
+	if host.Users.IsContain(login) {
+		return nil
+	}
+	for _, grant := range GetGrants() {
+		status := grant.GetStatus(now)
+		if !grant.MatchHost(host) || (status != GrantExpired && status != GrantRevoked) {
+			continue
+		}
+		if user := Users.GetByAlias(grant.User); user != nil && user.Login == login {
+			return grant
+		}
+	}
+	return nil
+}
+
+// GetBoundaries lists the times at which grants of the host start or end.
+func (grants TGrants) GetBoundaries(host *THost) []time.Time {
+	var boundaries []time.Time
+	for _, grant := range grants {
+		if !grant.MatchHost(host) {
+			continue
+		}
+		boundaries = append(boundaries, grant.From, grant.Until)
+		if grant.Revoked != nil {
+			boundaries = append(boundaries, *grant.Revoked)
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+	return boundaries
+}
+
+// StartGrants wakes the user jobs of a host when one of its grants starts or
+// ends. Boundaries passed while the service was down are caught up on start,
+// the time of the last check is kept in state.
+func StartGrants() {
+	go func() {
+		for {
+			err := LoadGrants()
+			if err != nil {
+				Log.Error("grants error", "error", err)
+			}
+			now := time.Now()
+			checked := GetGrantsChecked()
+			grants := GetGrants()
+			triggered := false
+			for _, host := range Hosts {
+				for _, boundary := range grants.GetBoundaries(host) {
+					if boundary.Unix() > checked && !boundary.After(now) {
+						host.TriggerJobs(TListOfStrings{"clean_users", "add_users"}, "grant boundary "+boundary.Format(time.RFC3339))
+						triggered = true
+						break
+					}
+				}
+			}
+			SetGrantsChecked(now, triggered)
+			time.Sleep(grantCheckInterval)
+		}
+	}()
+}
+
+// TriggerJobs wakes every job of the host running one of the steps.
+func (host *THost) TriggerJobs(steps TListOfStrings, reason string) {
+	triggered := false
+	for _, job := range host.Jobs {
+		for _, step := range steps {
+			if job.Sequence.IsContain(step) {
+				host.Log().Info("waking job", "job", job.Name, "reason", reason)
+				job.Trigger(TRunOptions{})
+				triggered = true
+				break
+			}
+		}
+	}
+	if !triggered {
+		host.Log().Warn("no job runs the steps", "steps", steps, "reason", reason)
+	}
+}
+
+func GetGrantsChecked() int64 {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return grantsChecked
+}
+
+// SetGrantsChecked saves state only when jobs were woken, a boundary seen
+// again after a restart just runs the user jobs once more.
+func SetGrantsChecked(checked time.Time, save bool) {
+	stateMutex.Lock()
+	grantsChecked = checked.Unix()
+	stateMutex.Unlock()
+	if !save {
+		return
+	}
+	err := SaveState()
+	if err != nil {
+		Log.Error("state error", "error", err)
+	}
+}
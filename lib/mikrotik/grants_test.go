@@ -0,0 +1,165 @@
+package mikrotik
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useGrants replaces users, hosts and grants for the test.
+func useGrants(t *testing.T, grants TGrants) *THost {
+	t.Helper()
+	savedUsers, savedHosts, savedGrants := Users, Hosts, Grants
+	t.Cleanup(func() { Users, Hosts, Grants = savedUsers, savedHosts, savedGrants })
+	Users = TUsers{{Login: "alice", Alias: "alice"}, {Login: "bob", Alias: "bob"}}
+	host := &THost{Name: "router", IP: "10.0.0.1", UsersAliases: TListOfStrings{"alice"}}
+	Hosts = THosts{host, {Name: "edge", IP: "10.0.0.2"}}
+	Grants = grants
+	return host
+}
+
+func TestGrantsLoad(t *testing.T) {
+	from := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	valid := func() *TGrant {
+		return &TGrant{ID: "g1", User: "bob", Hosts: TListOfStrings{"router"}, From: from, Until: from.Add(time.Hour)}
+	}
+	tests := []struct {
+		name   string
+		change func(grant *TGrant)
+		fails  bool
+	}{
+		{"valid", func(grant *TGrant) {}, false},
+		{"all hosts", func(grant *TGrant) { grant.Hosts = TListOfStrings{"*"} }, false},
+		{"empty id", func(grant *TGrant) { grant.ID = "" }, true},
+		{"unknown user", func(grant *TGrant) { grant.User = "carol" }, true},
+		{"no hosts", func(grant *TGrant) { grant.Hosts = nil }, true},
+		{"unknown host", func(grant *TGrant) { grant.Hosts = TListOfStrings{"core"} }, true},
+		{"until before from", func(grant *TGrant) { grant.Until = from }, true},
+	}
+	useGrants(t, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grant := valid()
+			test.change(grant)
+			if err := (TGrants{grant}).Load(); (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+	if err := (TGrants{valid(), valid()}).Load(); err == nil {
+		t.Error("duplicate id is accepted")
+	}
+}
+
+func TestGrantStatus(t *testing.T) {
+	from := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	grant := &TGrant{From: from, Until: from.Add(time.Hour)}
+	tests := []struct {
+		now    time.Time
+		status string
+	}{
+		{from.Add(-time.Minute), GrantPending},
+		{from, GrantActive},
+		{from.Add(time.Hour), GrantExpired},
+	}
+	for _, test := range tests {
+		if status := grant.GetStatus(test.now); status != test.status {
+			t.Errorf("%v: expected %s, got %s", test.now, test.status, status)
+		}
+	}
+	revoked := from.Add(time.Minute)
+	grant.Revoked = &revoked
+	if status := grant.GetStatus(from.Add(2 * time.Minute)); status != GrantRevoked {
+		t.Errorf("expected revoked, got %s", status)
+	}
+}
+
+func TestGrantUsers(t *testing.T) {
+	from := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	revoked := from.Add(30 * time.Minute)
+	host := useGrants(t, TGrants{
+		{ID: "g1", User: "bob", Hosts: TListOfStrings{"router"}, From: from, Until: from.Add(time.Hour)},
+		{ID: "g2", User: "bob", Hosts: TListOfStrings{"10.0.0.2"}, From: from, Until: from.Add(2 * time.Hour), Revoked: &revoked},
+	})
+	if users := host.GetConfiguredUsers(from.Add(-time.Minute)); len(users) != 1 {
+		t.Errorf("pending grant adds a user: %v", users)
+	}
+	if users := host.GetConfiguredUsers(from); len(users) != 2 || !users.IsContain("bob") {
+		t.Errorf("active grant does not add its user: %v", users)
+	}
+	host.Users = host.GetConfiguredUsers(from)
+	if grant := host.GetEndedGrant("bob", from); grant != nil {
+		t.Errorf("user of an active grant is removed by %s", grant.ID)
+	}
+	host.Users = host.GetConfiguredUsers(from.Add(time.Hour))
+	if grant := host.GetEndedGrant("bob", from.Add(time.Hour)); grant == nil || grant.ID != "g1" {
+		t.Errorf("expired grant is not found: %v", grant)
+	}
+	if grant := host.GetEndedGrant("alice", from.Add(time.Hour)); grant != nil {
+		t.Errorf("configured user is removed by %s", grant.ID)
+	}
+	edge := Hosts[1]
+	if grant := edge.GetEndedGrant("bob", from.Add(time.Hour)); grant == nil || grant.ID != "g2" {
+		t.Errorf("revoked grant is not found: %v", grant)
+	}
+	boundaries := Grants.GetBoundaries(edge)
+	if len(boundaries) != 3 || !boundaries[1].Equal(revoked) {
+		t.Errorf("unexpected boundaries %v", boundaries)
+	}
+}
+
+func TestParseGrantTime(t *testing.T) {
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"", base},
+		{"4h", base.Add(4 * time.Hour)},
+		{"2d", base.Add(48 * time.Hour)},
+		{"1w", base.Add(7 * 24 * time.Hour)},
+		{"2024-03-05", time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)},
+		{"2024-03-05T18:30", time.Date(2024, 3, 5, 18, 30, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		parsed, err := ParseGrantTime(test.value, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !parsed.Equal(test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, parsed)
+		}
+	}
+	if _, err := ParseGrantTime("soon", base); err == nil {
+		t.Error("invalid time is accepted")
+	}
+}
+
+func TestLoadGrants(t *testing.T) {
+	dir := useParams(t, map[string]string{})
+	Params = append(Params, &TParam{Name: "dir_mikrotik-config", Value: dir + string(filepath.Separator)})
+	useGrants(t, nil)
+	grantsModified = time.Time{}
+	if err := LoadGrants(); err != nil || len(GetGrants()) != 0 {
+		t.Fatalf("missing file is not empty: %v %v", GetGrants(), err)
+	}
+	from := time.Now().Truncate(time.Second)
+	if err := SaveGrants(TGrants{{ID: "g1", User: "bob", Hosts: TListOfStrings{"*"}, From: from, Until: from.Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadGrants(); err != nil || len(GetGrants()) != 1 {
+		t.Fatalf("saved grant is not loaded: %v %v", GetGrants(), err)
+	}
+	// rosman grant writes the file while the service runs
+	if err := SaveGrants(TGrants{}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(GetGrantsPath(), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadGrants(); err != nil || len(GetGrants()) != 0 {
+		t.Errorf("changed file is not loaded again: %v %v", GetGrants(), err)
+	}
+}
@@ -0,0 +1,526 @@
+package mikrotik
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TRuns []*TRun
+type TRun struct {
+	ID             string         `json:"id"`
+	Host           string         `json:"host"`
+	IP             string         `json:"ip"`
+	Job            string         `json:"job"`
+	Start          time.Time      `json:"start"`
+	End            time.Time      `json:"end"`
+	Status         string         `json:"status"`
+	Error          string         `json:"error,omitempty"`
+	ForceDeletions bool           `json:"force_deletions,omitempty"`
+	Steps          TStepResults   `json:"steps"`
+	Hooks          []*THookResult `json:"hooks,omitempty"`
+}
+
+type TStepResults []*TStepResult
+type TStepResult struct {
+	Name       string         `json:"name"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	Created    []*TObjectRef  `json:"created,omitempty"`
+	Deleted    []*TObjectRef  `json:"deleted,omitempty"`
+	Disabled   []*TObjectRef  `json:"disabled,omitempty"`
+	Enabled    []*TObjectRef  `json:"enabled,omitempty"`
+	Rotated    []*TObjectRef  `json:"rotated,omitempty"`
+	Updated    []*TObjectRef  `json:"updated,omitempty"`
+	Downloaded []*TFileResult `json:"downloaded,omitempty"`
+}
+
+type TObjectRef struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type TFileResult struct {
+	Remote string `json:"remote"`
+	Local  string `json:"local"`
+	Size   int64  `json:"size"`
+}
+
+type THistoryFilter struct {
+	Host   string
+	Job    string
+	Object string
+	Since  time.Time
+}
+
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+var historyMutex sync.Mutex
+
+func GetHistoryPath() string {
+	param, err := Params.GetByName("file_history")
+	if err != nil {
+		return ""
+	}
+	return param.Value
+}
+
+func NewRunID() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(random)
+}
+
+func (host *THost) BeginRun(job string) *TRun {
+	host.run = &TRun{
+		ID:    NewRunID(),
+		Host:  host.Name,
+		IP:    host.IP,
+		Job:   job,
+		Start: time.Now(),
+	}
+	host.setLogContext("job", job, "run_id", host.run.ID)
+	return host.run
+}
+
+func (host *THost) EndRun(err error) *TRun {
+	run := host.run
+	if run == nil {
+		return nil
+	}
+	run.End = time.Now()
+	run.Status = StatusSuccess
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+	}
+	host.Log().Info("run finished", "status", run.Status, "duration", run.End.Sub(run.Start).Round(time.Millisecond))
+	host.EmitRunResult(run)
+	host.run = nil
+	host.setLogContext()
+	run.ObserveMetrics()
+	err = AppendHistory(run)
+	if err != nil {
+		host.Log().Error("history error", "error", err)
+	}
+	return run
+}
+
+func (host *THost) BeginStep(name string) {
+	if host.run == nil {
+		return
+	}
+	host.run.Steps = append(host.run.Steps, &TStepResult{Name: name, Start: time.Now()})
+	host.setLogContext("job", host.run.Job, "run_id", host.run.ID, "step", name)
+}
+
+func (host *THost) EndStep(err error) {
+	step := host.GetStepResult()
+	if step == nil {
+		return
+	}
+	step.End = time.Now()
+	host.setLogContext("job", host.run.Job, "run_id", host.run.ID)
+	step.Status = StatusSuccess
+	if err != nil {
+		step.Status = StatusFailed
+		step.Error = err.Error()
+	}
+	step.ObserveMetrics(host)
+}
+
+func (host *THost) GetStepResult() *TStepResult {
+	if host.run == nil || len(host.run.Steps) == 0 {
+		return nil
+	}
+	return host.run.Steps[len(host.run.Steps)-1]
+}
+
+func (host *THost) RecordCreated(kind string, name string) {
+	MetricObjectsCreated.Add(1, host.Name, kind)
+	if step := host.GetStepResult(); step != nil {
+		step.Created = append(step.Created, &TObjectRef{Kind: kind, Name: name})
+	}
+}
+
+func (host *THost) RecordDeleted(kind string, name string, reason string) {
+	MetricObjectsRemoved.Add(1, host.Name, kind)
+	host.EmitRun(EventObjectRemoved, fmt.Sprintf("%s \"%s\" removed (%s)", kind, name, reason), map[string]string{"kind": kind, "name": name, "reason": reason})
+	if step := host.GetStepResult(); step != nil {
+		step.Deleted = append(step.Deleted, &TObjectRef{Kind: kind, Name: name, Reason: reason})
+	}
+}
+
+func (host *THost) RecordDisabled(kind string, name string, reason string) {
+	MetricObjectsDisabled.Add(1, host.Name, kind)
+	host.EmitRun(EventObjectDisabled, fmt.Sprintf("%s \"%s\" disabled (%s)", kind, name, reason), map[string]string{"kind": kind, "name": name, "reason": reason})
+	if step := host.GetStepResult(); step != nil {
+		step.Disabled = append(step.Disabled, &TObjectRef{Kind: kind, Name: name, Reason: reason})
+	}
+}
+
+func (host *THost) RecordEnabled(kind string, name string, reason string) {
+	if step := host.GetStepResult(); step != nil {
+		step.Enabled = append(step.Enabled, &TObjectRef{Kind: kind, Name: name, Reason: reason})
+	}
+}
+
+func (host *THost) RecordRotated(kind string, name string, reason string) {
+	if step := host.GetStepResult(); step != nil {
+		step.Rotated = append(step.Rotated, &TObjectRef{Kind: kind, Name: name, Reason: reason})
+	}
+}
+
+func (host *THost) RecordUpdated(kind string, name string, reason string) {
+	if step := host.GetStepResult(); step != nil {
+		step.Updated = append(step.Updated, &TObjectRef{Kind: kind, Name: name, Reason: reason})
+	}
+}
+
+func (host *THost) RecordDownloaded(remote string, local string, size int64) {
+	MetricDownloadedBytes.Add(float64(size), host.Name)
+	host.EmitRun(EventBackupStored, fmt.Sprintf("file \"%s\" stored to \"%s\" (%d bytes)", remote, local, size), map[string]string{"remote": remote, "local": local, "size": fmt.Sprint(size)})
+	if step := host.GetStepResult(); step != nil {
+		step.Downloaded = append(step.Downloaded, &TFileResult{Remote: remote, Local: local, Size: size})
+	}
+}
+
+func (host *THost) EmitRunResult(run *TRun) {
+	if run.Status == StatusFailed {
+		data := map[string]string{"error": run.Error, "class": ClassifyError(errors.New(run.Error)).String()}
+		if step := host.GetStepResult(); step != nil {
+			data["step"] = step.Name
+		}
+		host.EmitRun(EventRunFailed, fmt.Sprintf("run \"%s\" failed: %s", run.Job, run.Error), data)
+		return
+	}
+	var changes []string
+	for _, step := range run.Steps {
+		for _, object := range step.Created {
+			changes = append(changes, fmt.Sprintf("created %s \"%s\"", object.Kind, object.Name))
+		}
+		for _, object := range step.Deleted {
+			changes = append(changes, fmt.Sprintf("deleted %s \"%s\"", object.Kind, object.Name))
+		}
+		for _, object := range step.Disabled {
+			changes = append(changes, fmt.Sprintf("disabled %s \"%s\"", object.Kind, object.Name))
+		}
+		for _, object := range step.Enabled {
+			changes = append(changes, fmt.Sprintf("enabled %s \"%s\"", object.Kind, object.Name))
+		}
+		for _, object := range step.Rotated {
+			changes = append(changes, fmt.Sprintf("rotated password of %s \"%s\"", object.Kind, object.Name))
+		}
+		for _, object := range step.Updated {
+			changes = append(changes, fmt.Sprintf("updated %s of %s \"%s\"", object.Reason, object.Kind, object.Name))
+		}
+	}
+	if len(changes) > 0 {
+		message := fmt.Sprintf("drift corrected by \"%s\": %s", run.Job, strings.Join(changes, ", "))
+		host.EmitRun(EventDriftCorrected, message, map[string]string{"changes": fmt.Sprint(len(changes))})
+	}
+}
+
+// GetHistoryFile returns the history file rotated by size like the log file,
+// so that a query reads at most history_max_backups+1 files.
+func GetHistoryFile(path string) (*TRotatingFile, error) {
+	file := &TRotatingFile{Path: path, MaxSize: 10 << 20, MaxBackups: 5}
+	if value := Params.GetValue("history_max_size"); value != "" {
+		megabytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid history_max_size \"%s\"", value))
+		}
+		file.MaxSize = megabytes << 20
+	}
+	if value := Params.GetValue("history_max_backups"); value != "" {
+		var err error
+		file.MaxBackups, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid history_max_backups \"%s\"", value))
+		}
+	}
+	return file, nil
+}
+
+func AppendHistory(run *TRun) error {
+	path := GetHistoryPath()
+	if path == "" {
+		return nil
+	}
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	file, err := GetHistoryFile(path)
+	if err != nil {
+		return err
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	err = file.open()
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		_ = file.file.Close()
+		return err
+	}
+	return file.file.Close()
+}
+
+// GetHistoryPaths returns the rotated files from the oldest one and the
+// current file last.
+func GetHistoryPaths(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := map[int]string{}
+	var numbers []int
+	for _, match := range matches {
+		number, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || number <= 0 {
+			continue
+		}
+		backups[number] = match
+		numbers = append(numbers, number)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	var paths []string
+	for _, number := range numbers {
+		paths = append(paths, backups[number])
+	}
+	return append(paths, path), nil
+}
+
+// ReadHistory scans the history files in order. A file last written before
+// filter.Since holds only older runs and is not opened.
+func ReadHistory(filter THistoryFilter) (TRuns, error) {
+	var runs TRuns
+	path := GetHistoryPath()
+	if path == "" {
+		return runs, errors.New("param \"file_history\" is not configured")
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return runs, err
+	}
+	defer lock.Unlock()
+	paths, err := GetHistoryPaths(path)
+	if err != nil {
+		return runs, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return runs, err
+		}
+		if !filter.Since.IsZero() && info.ModTime().Before(filter.Since) {
+			continue
+		}
+		err = scanHistory(path, func(run *TRun) {
+			if filter.Match(run) {
+				runs = append(runs, run)
+			}
+		})
+		if err != nil {
+			return runs, err
+		}
+	}
+	return runs, nil
+}
+
+// ReadLastRun returns the latest run matching the filter or nil. Files are
+// read newest first from their end, so the read stops at the last run of the
+// host instead of scanning the whole history.
+func ReadLastRun(filter THistoryFilter) (*TRun, error) {
+	path := GetHistoryPath()
+	if path == "" {
+		return nil, errors.New("param \"file_history\" is not configured")
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	paths, err := GetHistoryPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	var last *TRun
+	for i := len(paths) - 1; i >= 0 && last == nil; i-- {
+		err = scanHistoryReverse(paths[i], func(run *TRun) bool {
+			if filter.Match(run) {
+				last = run
+			}
+			return last != nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return last, nil
+}
+
+const historyChunkSize = 64 * 1024
+
+// scanHistoryReverse calls fn with the runs of the file from the last one
+// back until fn returns true.
+func scanHistoryReverse(path string, fn func(run *TRun) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	var carry []byte
+	for offset > 0 {
+		size := int64(historyChunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		block := make([]byte, size, size+int64(len(carry)))
+		_, err = file.ReadAt(block, offset)
+		if err != nil {
+			return err
+		}
+		lines := bytes.Split(append(block, carry...), []byte("\n"))
+		// the first line may continue in the previous block
+		first := 1
+		if offset == 0 {
+			first = 0
+		}
+		carry = lines[0]
+		for i := len(lines) - 1; i >= first; i-- {
+			if len(bytes.TrimSpace(lines[i])) == 0 {
+				continue
+			}
+			var run TRun
+			err = json.Unmarshal(lines[i], &run)
+			if err != nil {
+				Log.Warn("history record skipped", "path", path, "error", err)
+				continue
+			}
+			if fn(&run) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func scanHistory(path string, fn func(run *TRun)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var run TRun
+		err = json.Unmarshal(scanner.Bytes(), &run)
+		if err != nil {
+			Log.Warn("history record skipped", "path", path, "error", err)
+			continue
+		}
+		fn(&run)
+	}
+	return scanner.Err()
+}
+
+func (filter THistoryFilter) Match(run *TRun) bool {
+	if filter.Host != "" && filter.Host != run.Host && filter.Host != run.IP {
+		return false
+	}
+	if filter.Job != "" && filter.Job != run.Job {
+		return false
+	}
+	if !filter.Since.IsZero() && run.Start.Before(filter.Since) {
+		return false
+	}
+	if filter.Object != "" && !run.HasObject(filter.Object) {
+		return false
+	}
+	return true
+}
+
+func (run *TRun) HasObject(name string) bool {
+	for _, step := range run.Steps {
+		var objects []*TObjectRef
+		for _, list := range [][]*TObjectRef{step.Created, step.Deleted, step.Disabled, step.Enabled, step.Rotated, step.Updated} {
+			objects = append(objects, list...)
+		}
+		for _, object := range objects {
+			if object.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		since, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return since, nil
+		}
+	}
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return time.Time{}, errors.New(fmt.Sprintf("invalid period \"%s\"", value))
+			}
+			return now.Add(-time.Duration(count) * unit), nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("invalid period \"%s\"", value))
+	}
+	return now.Add(-duration), nil
+}
@@ -0,0 +1,115 @@
+package mikrotik
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryHasObject(t *testing.T) {
+	run := &TRun{Steps: TStepResults{
+		{Name: "clean_users", Deleted: []*TObjectRef{{Kind: "user", Name: "deleted"}}, Disabled: []*TObjectRef{{Kind: "user", Name: "disabled"}}},
+		{Name: "add_users", Created: []*TObjectRef{{Kind: "user", Name: "created"}}, Enabled: []*TObjectRef{{Kind: "user", Name: "enabled"}}},
+		{Name: "rotate_passwords", Rotated: []*TObjectRef{{Kind: "user", Name: "rotated"}}},
+	}}
+	for _, name := range []string{"deleted", "disabled", "created", "enabled", "rotated"} {
+		if !run.HasObject(name) {
+			t.Errorf("object %q is not found", name)
+		}
+	}
+	if run.HasObject("other") {
+		t.Error("unknown object is found")
+	}
+}
+
+func TestHistoryRotation(t *testing.T) {
+	dir := useParams(t, map[string]string{"file_history": "history.jsonl"})
+	Params = append(Params, &TParam{Name: "history_max_size", Value: "0"}, &TParam{Name: "history_max_backups", Value: "2"})
+	path := filepath.Join(dir, "history.jsonl")
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		run := &TRun{ID: string(rune('a' + i)), Host: "router", Start: start.Add(time.Duration(i) * time.Minute)}
+		if err := AppendHistory(run); err != nil {
+			t.Fatal(err)
+		}
+		file, _ := GetHistoryFile(path)
+		file.MaxSize = 1
+		if err := file.open(); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		_ = file.file.Close()
+	}
+	runs, err := ReadHistory(THistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "c" || runs[1].ID != "d" {
+		t.Fatalf("expected the last 2 runs oldest first, got %v", runs)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path+".2", old, old); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = ReadHistory(THistoryFilter{Since: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != "d" {
+		t.Errorf("file written before since is read: %v", runs)
+	}
+}
+
+func TestHostLogContext(t *testing.T) {
+	useParams(t, map[string]string{"file_history": ""})
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	fields := func() string { return fmt.Sprintln(host.Log().Fields...) }
+	run := host.BeginRun("sync")
+	host.BeginStep("add_users")
+	done := make(chan string)
+	// a handler of the API logs for the host while the step runs
+	go func() { done <- fields() }()
+	if logged := <-done; !strings.Contains(logged, "step add_users") || !strings.Contains(logged, run.ID) {
+		t.Errorf("step context is missing: %s", logged)
+	}
+	host.EndStep(nil)
+	if logged := fields(); strings.Contains(logged, "step") {
+		t.Errorf("ended step is still logged: %s", logged)
+	}
+	host.EndRun(nil)
+	if logged := fields(); strings.Contains(logged, "run_id") {
+		t.Errorf("ended run is still logged: %s", logged)
+	}
+}
+
+func TestReadLastRun(t *testing.T) {
+	useParams(t, map[string]string{"file_history": "history.jsonl"})
+	start := time.Now().Add(-time.Hour)
+	// enough runs of another host to span several blocks of the reverse read
+	for i := 0; i < 1500; i++ {
+		ip := "10.0.0.2"
+		if i == 3 || i == 7 {
+			ip = "10.0.0.1"
+		}
+		run := &TRun{ID: fmt.Sprint(i), Host: "router", IP: ip, Job: "sync", Start: start}
+		if err := AppendHistory(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run, err := ReadLastRun(THistoryFilter{Host: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run == nil || run.ID != "7" {
+		t.Fatalf("expected run 7, got %v", run)
+	}
+	run, err = ReadLastRun(THistoryFilter{Host: "10.0.0.1", Job: "backup"})
+	if err != nil || run != nil {
+		t.Fatalf("expected no run, got %v %v", run, err)
+	}
+}
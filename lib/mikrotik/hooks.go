@@ -0,0 +1,213 @@
+package mikrotik
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type THooks []*THook
+type THook struct {
+	Name    string         `json:"name"`
+	Enabled bool           `json:"enabled"`
+	Event   string         `json:"event"`
+	Command string         `json:"command"`
+	Args    TListOfStrings `json:"args"`
+	Timeout int            `json:"timeout"`
+	Mode    string         `json:"mode"`
+	Hosts   TListOfStrings `json:"hosts"`
+	Jobs    TListOfStrings `json:"jobs"`
+	Note    string         `json:"note"`
+}
+
+type THookResult struct {
+	Name     string    `json:"name"`
+	Event    string    `json:"event"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+}
+
+const (
+	HookPreRun             = "pre_run"
+	HookPostRun            = "post_run"
+	HookPostBackupDownload = "post_backup_download"
+	HookOnDeletion         = "on_deletion"
+)
+
+const (
+	HookModeVeto = "veto"
+	HookModeWarn = "warn"
+)
+
+const (
+	hookDefaultTimeout = 60
+	hookMaxOutput      = 64 * 1024
+)
+
+var hookEvents = TListOfStrings{HookPreRun, HookPostRun, HookPostBackupDownload, HookOnDeletion}
+
+var Hooks THooks
+
+func (hooks THooks) Load() error {
+	for _, hook := range hooks {
+		if !hookEvents.IsContain(hook.Event) {
+			return errors.New(fmt.Sprintf("hook \"%s\": event \"%s\" does not exist", hook.Name, hook.Event))
+		}
+		if hook.Command == "" {
+			return errors.New(fmt.Sprintf("hook \"%s\": command is required", hook.Name))
+		}
+		switch hook.Mode {
+		case "":
+			hook.Mode = HookModeWarn
+		case HookModeVeto, HookModeWarn:
+		default:
+			return errors.New(fmt.Sprintf("hook \"%s\": mode \"%s\" does not exist", hook.Name, hook.Mode))
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = hookDefaultTimeout
+		}
+	}
+	return nil
+}
+
+func (hook *THook) Match(host *THost, event TEvent) bool {
+	if !hook.Enabled || hook.Event != event.Type {
+		return false
+	}
+	if len(hook.Hosts) > 0 && !hook.Hosts.IsContain(host.Name) && !hook.Hosts.IsContain(host.IP) {
+		return false
+	}
+	return len(hook.Jobs) == 0 || hook.Jobs.IsContain(event.Job)
+}
+
+// RunHooks runs every hook configured for the event in order. A failed veto
+// hook stops the remaining hooks and is returned as a permanent error, a
+// failed warn hook is only logged.
+func (host *THost) RunHooks(eventType string, data map[string]string) error {
+	event := TEvent{Type: eventType, Time: time.Now().Unix(), Host: host.Name, IP: host.IP, Data: data}
+	if host.run != nil {
+		event.Job = host.run.Job
+		event.RunID = host.run.ID
+	}
+	for _, hook := range Hooks {
+		if !hook.Match(host, event) {
+			continue
+		}
+		result := host.RunHook(hook, event)
+		if host.run != nil {
+			host.run.Hooks = append(host.run.Hooks, result)
+		}
+		if result.Status == StatusSuccess {
+			continue
+		}
+		if hook.Mode == HookModeVeto {
+			host.Log().Error("hook vetoed", "hook", hook.Name, "event", eventType, "error", result.Error)
+			return &TPermanentError{Err: errors.New(fmt.Sprintf("vetoed by hook \"%s\" on %s: %s", hook.Name, eventType, result.Error))}
+		}
+		host.Log().Warn("hook failed", "hook", hook.Name, "event", eventType, "error", result.Error)
+	}
+	return nil
+}
+
+func (host *THost) RunHook(hook *THook, event TEvent) *THookResult {
+	result := &THookResult{Name: hook.Name, Event: event.Type, Start: time.Now(), Status: StatusSuccess}
+	defer func() { result.End = time.Now() }()
+	input, err := json.Marshal(event)
+	if err != nil {
+		result.Status, result.Error = StatusFailed, err.Error()
+		return result
+	}
+	cmd := exec.Command(hook.Command, hook.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"ROSMAN_EVENT="+event.Type,
+		"ROSMAN_HOST="+event.Host,
+		"ROSMAN_IP="+event.IP,
+		"ROSMAN_JOB="+event.Job,
+		"ROSMAN_RUN_ID="+event.RunID,
+	)
+	// The output goes to a file, not a pipe: a background process started by
+	// the hook would keep a pipe open and Wait would not return.
+	file, err := ioutil.TempFile("", "rosman-hook-*")
+	if err != nil {
+		result.Status, result.Error = StatusFailed, err.Error()
+		return result
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	cmd.Stdout = file
+	cmd.Stderr = file
+	setHookProcessGroup(cmd)
+	host.Log().Debug("hook started", "hook", hook.Name, "event", event.Type, "command", hook.Command)
+	timedOut := false
+	err = cmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		timer := time.NewTimer(time.Duration(hook.Timeout) * time.Second)
+		select {
+		case err = <-done:
+			timer.Stop()
+		case <-timer.C:
+			timedOut = true
+			killHookProcess(cmd)
+			err = <-done
+		}
+	}
+	output := &tHookOutput{limit: hookMaxOutput}
+	if _, errSeek := file.Seek(0, io.SeekStart); errSeek == nil {
+		_, _ = io.Copy(output, io.LimitReader(file, hookMaxOutput+1))
+	}
+	result.Output = output.String()
+	scanner := bufio.NewScanner(strings.NewReader(result.Output))
+	for scanner.Scan() {
+		host.Log().Info("hook output", "hook", hook.Name, "line", scanner.Text())
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if timedOut {
+		err = errors.New(fmt.Sprintf("timeout after %d seconds", hook.Timeout))
+	}
+	if err != nil {
+		result.Status, result.Error = StatusFailed, err.Error()
+	}
+	return result
+}
+
+type tHookOutput struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (output *tHookOutput) Write(content []byte) (int, error) {
+	free := output.limit - output.buffer.Len()
+	if len(content) > free {
+		output.truncated = true
+		output.buffer.Write(content[:free])
+		return len(content), nil
+	}
+	return output.buffer.Write(content)
+}
+
+func (output *tHookOutput) String() string {
+	if output.truncated {
+		return output.buffer.String() + "\n[output truncated]"
+	}
+	return output.buffer.String()
+}
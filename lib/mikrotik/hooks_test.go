@@ -0,0 +1,41 @@
+package mikrotik
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		status   string
+		exitCode int
+		output   string
+		error    string
+	}{
+		{"success", `read input; echo "$ROSMAN_EVENT"`, StatusSuccess, 0, "pre_run", ""},
+		{"exit code", `echo failing >&2; exit 3`, StatusFailed, 3, "failing", "exit status 3"},
+		{"timeout kills children", `sleep 30 & echo started; sleep 30`, StatusFailed, -1, "started", "timeout after 1 seconds"},
+		{"background child keeps running", `(sleep 30 &); echo done`, StatusSuccess, 0, "done", ""},
+		{"output is limited", `head -c 100000 /dev/zero | tr '\0' x`, StatusSuccess, 0, "[output truncated]", ""},
+	}
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hook := &THook{Name: test.name, Command: "sh", Args: TListOfStrings{"-c", test.script}, Timeout: 1}
+			start := time.Now()
+			result := host.RunHook(hook, TEvent{Type: HookPreRun, Host: host.Name, IP: host.IP})
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("hook returned after %s", time.Since(start))
+			}
+			if result.Status != test.status || result.ExitCode != test.exitCode || result.Error != test.error {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if !strings.Contains(result.Output, test.output) || len(result.Output) > hookMaxOutput+100 {
+				t.Errorf("unexpected output %.100q", result.Output)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package mikrotik
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// The hook runs in its own process group, so a timeout also kills the
+// processes it started.
+func setHookProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killHookProcess(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package mikrotik
+
+import "os/exec"
+
+func setHookProcessGroup(cmd *exec.Cmd) {}
+
+func killHookProcess(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,423 @@
+package mikrotik
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type TImport struct {
+	Host             *THost         `json:"-"`
+	Users            TUsers         `json:"users"`
+	Groups           TGroups        `json:"groups"`
+	Schedules        TSchedules     `json:"schedules"`
+	Scripts          []*TScript     `json:"scripts"`
+	UsersAliases     TListOfStrings `json:"users_aliases"`
+	SchedulesAliases TListOfStrings `json:"schedules_aliases"`
+	Skipped          TListOfStrings `json:"skipped"`
+	files            map[string]string
+}
+
+type TScript struct {
+	Name   string `json:"name"`
+	Source string `json:"-"`
+	File   string `json:"file"`
+}
+
+var aliasInvalid = regexp.MustCompile(`[^a-z0-9_]+`)
+
+func MakeAlias(name string) string {
+	alias := strings.Trim(aliasInvalid.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if alias == "" {
+		alias = "imported"
+	}
+	return alias
+}
+
+func uniqueName(base string, taken func(name string) bool) string {
+	name := base
+	for i := 2; taken(name); i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return name
+}
+
+// Import reads users, groups and schedules from the device and prepares
+// config entries for everything that is not in config yet. Scripts from
+// /system/script are kept as files in dir_scripts, rosman does not manage
+// them. Nothing is written until Save is called.
+func (host *THost) Import() (*TImport, error) {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	defer host.Disconnect()
+	result := &TImport{Host: host, files: map[string]string{}}
+	dirScripts := Params.GetValue("dir_scripts")
+	fileTaken := func(name string) bool {
+		if _, ok := result.files[name]; ok {
+			return true
+		}
+		_, err := os.Stat(dirScripts + name)
+		return err == nil
+	}
+	groups, err := host.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if Groups.IsContain(group.Name) {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("group \"%s\" is already in config", group.Name))
+			continue
+		}
+		group.Allow, group.Policy = ImportPolicy(group.Policy), ""
+		result.Groups = append(result.Groups, group)
+	}
+	users, err := host.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if reason := host.GetImportSkipReason(user); reason != "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("user \"%s\" %s", user.Login, reason))
+			continue
+		}
+		if existing := Users.GetByLogin(user.Login); existing != nil {
+			if !host.UsersAliases.IsContain(existing.Alias) {
+				result.UsersAliases = appendUnique(result.UsersAliases, existing.Alias)
+			}
+			result.Skipped = append(result.Skipped, fmt.Sprintf("user \"%s\" is already in config as \"%s\"", user.Login, existing.Alias))
+			continue
+		}
+		user.Alias = uniqueName(MakeAlias(user.Login), func(alias string) bool {
+			return Users.GetByAlias(alias) != nil || result.Users.GetByAlias(alias) != nil
+		})
+		result.Users = append(result.Users, user)
+		result.UsersAliases = append(result.UsersAliases, user.Alias)
+	}
+	schedules, err := host.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range schedules {
+		if existing := Schedules.GetByName(schedule.Name); existing != nil {
+			if !host.SchedulesAliases.IsContain(existing.Alias) {
+				result.SchedulesAliases = appendUnique(result.SchedulesAliases, existing.Alias)
+			}
+			result.Skipped = append(result.Skipped, fmt.Sprintf("schedule \"%s\" is already in config as \"%s\"", schedule.Name, existing.Alias))
+			continue
+		}
+		schedule.Alias = uniqueName(MakeAlias(schedule.Name), func(alias string) bool {
+			return Schedules.GetByAlias(alias) != nil || result.Schedules.GetByAlias(alias) != nil
+		})
+		schedule.Script = uniqueName(schedule.Alias, func(name string) bool { return fileTaken(name + ".rsc") }) + ".rsc"
+		result.files[schedule.Script] = schedule.OnEvent
+		result.Schedules = append(result.Schedules, schedule)
+		result.SchedulesAliases = append(result.SchedulesAliases, schedule.Alias)
+	}
+	scripts, err := host.GetScripts()
+	if err != nil {
+		return nil, err
+	}
+	for _, script := range scripts {
+		if file := result.findScriptFile(dirScripts, script.Source); file != "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("script \"%s\" is already in dir_scripts as \"%s\"", script.Name, file))
+			continue
+		}
+		script.File = uniqueName(MakeAlias(script.Name), func(name string) bool { return fileTaken(name + ".rsc") }) + ".rsc"
+		result.files[script.File] = script.Source
+		result.Scripts = append(result.Scripts, script)
+	}
+	return result, nil
+}
+
+// findScriptFile returns the script file with the same source, imported in
+// this run or already in dir_scripts, so a script run by a schedule is kept
+// once.
+func (result *TImport) findScriptFile(dirScripts string, source string) string {
+	source = strings.TrimSpace(source)
+	var names []string
+	for name := range result.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.TrimSpace(result.files[name]) == source {
+			return name
+		}
+	}
+	entries, _ := ioutil.ReadDir(dirScripts)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(dirScripts + entry.Name())
+		if err == nil && strings.TrimSpace(string(content)) == source {
+			return entry.Name()
+		}
+	}
+	return ""
+}
+
+// GetImportSkipReason returns why a device user does not become a config
+// entry, quarantined users would be imported active with the marker.
+func (host *THost) GetImportSkipReason(user *TUser) string {
+	switch {
+	case user.Login == host.Login:
+		return "is the rosman login"
+	case Breakglass.IsLogin(user.Login):
+		return "is the break-glass login"
+	case strings.HasPrefix(user.Comment, QuarantineMarker+" "):
+		return "is quarantined, add it to config to release it"
+	case user.disabled:
+		return "is disabled on the device"
+	}
+	return ""
+}
+
+// ImportPolicy turns a policy printed by the device into the allowed
+// policies of groups.json, the others are denied when the group is rendered.
+func ImportPolicy(policy string) TListOfStrings {
+	allow := TListOfStrings{}
+	for _, token := range SplitPolicy(policy) {
+		if !strings.HasPrefix(token, "!") && !allow.IsContain(token) {
+			allow = append(allow, token)
+		}
+	}
+	return allow
+}
+
+func (host *THost) GetScripts() ([]*TScript, error) {
+	var scripts []*TScript
+	res, err := host.RunAPI("/system/script/print")
+	if err != nil {
+		return scripts, err
+	}
+	for _, el := range res.Re {
+		scripts = append(scripts, &TScript{Name: el.Map["name"], Source: el.Map["source"]})
+	}
+	return scripts, nil
+}
+
+// Save merges imported entries into users.json, groups.json, schedules.json
+// and the aliases of the host in hosts.json, and writes script files.
+func (result *TImport) Save() error {
+	dirCfg := Params.GetValue("dir_mikrotik-config")
+	dirScripts := Params.GetValue("dir_scripts")
+	err := os.MkdirAll(dirScripts, 0755)
+	if err != nil {
+		return err
+	}
+	for name, content := range result.files {
+		err = ioutil.WriteFile(dirScripts+name, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if len(result.Users) > 0 {
+		err = SaveJSON(append(append(TUsers{}, Users...), result.Users...), dirCfg+"users.json")
+		if err != nil {
+			return err
+		}
+	}
+	if len(result.Groups) > 0 {
+		err = SaveJSON(append(append(TGroups{}, Groups...), result.Groups...), dirCfg+"groups.json")
+		if err != nil {
+			return err
+		}
+	}
+	if len(result.Schedules) > 0 {
+		err = SaveJSON(append(append(TSchedules{}, Schedules...), result.Schedules...), dirCfg+"schedules.json")
+		if err != nil {
+			return err
+		}
+	}
+	if len(result.UsersAliases) == 0 && len(result.SchedulesAliases) == 0 {
+		return nil
+	}
+	return UpdateHostConfig(dirCfg+"hosts.json", result.Host.IP, map[string]interface{}{
+		"users_aliases":     append(append(TListOfStrings{}, result.Host.UsersAliases...), result.UsersAliases...),
+		"schedules_aliases": append(append(TListOfStrings{}, result.Host.SchedulesAliases...), result.SchedulesAliases...),
+	})
+}
+
+// SaveJSON writes the variable keeping the indentation of the existing file.
+func SaveJSON(variable interface{}, jsonPath string) error {
+	indent := " "
+	if content, err := ioutil.ReadFile(jsonPath); err == nil {
+		indent = detectIndent(content)
+	}
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	err := encoder.Encode(variable)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(jsonPath, content.Bytes())
+}
+
+func detectIndent(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return " "
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	err := ioutil.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+	Log.Info("config saved", "file", path)
+	return os.Rename(path+".tmp", path)
+}
+
+// UpdateHostConfig sets fields of one host in hosts.json. The file is edited
+// as raw JSON so that key order and fields rosman does not know are kept.
+func UpdateHostConfig(jsonPath string, ip string, fields map[string]interface{}) error {
+	content, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		return err
+	}
+	var hosts []json.RawMessage
+	err = json.Unmarshal(content, &hosts)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i, raw := range hosts {
+		var probe struct {
+			IP string `json:"ip"`
+		}
+		err = json.Unmarshal(raw, &probe)
+		if err != nil {
+			return err
+		}
+		if probe.IP != ip {
+			continue
+		}
+		found = true
+		hosts[i], err = setRawFields(raw, fields)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("host \"%s\" is not in \"%s\"", ip, filepath.Base(jsonPath)))
+	}
+	var compact bytes.Buffer
+	encoder := json.NewEncoder(&compact)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(hosts)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	err = json.Indent(&indented, bytes.TrimSpace(compact.Bytes()), "", detectIndent(content))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(jsonPath, append(indented.Bytes(), '\n'))
+}
+
+func setRawFields(raw json.RawMessage, fields map[string]interface{}) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	_, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	written := map[string]bool{}
+	writeField := func(key string, value []byte) {
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+		written[key] = true
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		if replacement, ok := fields[key]; ok {
+			value, err = json.Marshal(replacement)
+			if err != nil {
+				return nil, err
+			}
+		}
+		writeField(key, value)
+	}
+	var keys []string
+	for key := range fields {
+		if !written[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := json.Marshal(fields[key])
+		if err != nil {
+			return nil, err
+		}
+		writeField(key, value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (users TUsers) GetByLogin(login string) *TUser {
+	for _, user := range users {
+		if user.Login == login {
+			return user
+		}
+	}
+	return nil
+}
+
+func (users TUsers) GetByAlias(alias string) *TUser {
+	for _, user := range users {
+		if user.Alias == alias {
+			return user
+		}
+	}
+	return nil
+}
+
+func (schedules TSchedules) GetByName(name string) *TSchedule {
+	for _, schedule := range schedules {
+		if schedule.Name == name {
+			return schedule
+		}
+	}
+	return nil
+}
+
+func (schedules TSchedules) GetByAlias(alias string) *TSchedule {
+	for _, schedule := range schedules {
+		if schedule.Alias == alias {
+			return schedule
+		}
+	}
+	return nil
+}
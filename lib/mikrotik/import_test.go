@@ -0,0 +1,101 @@
+package mikrotik
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetImportSkipReason(t *testing.T) {
+	saved := Breakglass
+	t.Cleanup(func() { Breakglass = saved })
+	Breakglass = TBreakglass{Enabled: true, Login: "emergency"}
+	host := &THost{Login: "rosman"}
+	tests := []struct {
+		name    string
+		user    *TUser
+		skipped bool
+	}{
+		{"rosman login", &TUser{Login: "rosman"}, true},
+		{"break-glass login", &TUser{Login: "emergency"}, true},
+		{"quarantined", &TUser{Login: "bob", Comment: FormatQuarantineComment(time.Now(), "Bob"), disabled: true}, true},
+		{"disabled", &TUser{Login: "bob", disabled: true}, true},
+		{"active", &TUser{Login: "bob", Comment: "Bob"}, false},
+	}
+	for _, test := range tests {
+		if reason := host.GetImportSkipReason(test.user); (reason != "") != test.skipped {
+			t.Errorf("%s: expected skipped %v, got %q", test.name, test.skipped, reason)
+		}
+	}
+}
+
+func TestFindScriptFile(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	if err := ioutil.WriteFile(dir+"backup.rsc", []byte("/system backup save\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"old", 0755); err != nil {
+		t.Fatal(err)
+	}
+	result := &TImport{files: map[string]string{"cleanup.rsc": "/log print"}}
+	tests := []struct {
+		source string
+		file   string
+	}{
+		{"/system backup save", "backup.rsc"},
+		{"/log print\n", "cleanup.rsc"},
+		{"/ip address print", ""},
+	}
+	for _, test := range tests {
+		if file := result.findScriptFile(dir, test.source); file != test.file {
+			t.Errorf("%q: expected %q, got %q", test.source, test.file, file)
+		}
+	}
+}
+
+func TestImportSave(t *testing.T) {
+	dir := useParams(t, map[string]string{})
+	Params = append(Params,
+		&TParam{Name: "dir_mikrotik-config", Value: dir + string(filepath.Separator)},
+		&TParam{Name: "dir_scripts", Value: filepath.Join(dir, "scripts") + string(filepath.Separator)})
+	savedUsers := Users
+	t.Cleanup(func() { Users = savedUsers })
+	Users = TUsers{{Login: "alice", Alias: "alice"}}
+	hosts := "[\n {\n  \"ip\": \"10.0.0.1\",\n  \"users_aliases\": [\"alice\"]\n }\n]\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hosts.json"), []byte(hosts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := &TImport{
+		Host:         &THost{IP: "10.0.0.1", UsersAliases: TListOfStrings{"alice"}},
+		Users:        TUsers{{Login: "bob", Alias: "bob"}},
+		Scripts:      []*TScript{{Name: "Backup", File: "backup.rsc"}},
+		UsersAliases: TListOfStrings{"bob"},
+		files:        map[string]string{"backup.rsc": "/system backup save"},
+	}
+	if err := result.Save(); err != nil {
+		t.Fatal(err)
+	}
+	script, err := ioutil.ReadFile(filepath.Join(dir, "scripts", "backup.rsc"))
+	if err != nil || string(script) != "/system backup save" {
+		t.Errorf("script is not written: %q %v", script, err)
+	}
+	var users TUsers
+	if err := LoadJSON(&users, filepath.Join(dir, "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users.GetByLogin("bob") == nil {
+		t.Errorf("imported user is not merged: %v", users)
+	}
+	var config []struct {
+		IP           string         `json:"ip"`
+		UsersAliases TListOfStrings `json:"users_aliases"`
+	}
+	if err := LoadJSON(&config, filepath.Join(dir, "hosts.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(config) != 1 || !config[0].UsersAliases.IsContain("alice") || !config[0].UsersAliases.IsContain("bob") {
+		t.Errorf("host aliases are not updated: %v", config)
+	}
+}
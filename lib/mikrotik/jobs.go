@@ -0,0 +1,224 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type TJobs []*TJob
+type TJob struct {
+	Name        string         `json:"name"`
+	TaskName    string         `json:"task_name"`
+	Profile     string         `json:"profile"`
+	Sequence    TListOfStrings `json:"sequence"`
+	StepRetry   TRetries       `json:"step_retry"`
+	Task        *TTask
+	LastRun     int64
+	LastSuccess int64
+	LastError   string
+	Alerting    bool
+	Failures    int
+	trigger     chan struct{}
+	options     TRunOptions
+}
+
+const DefaultJobName = "default"
+
+var Actions = map[string]TListOfStrings{
+	"sync":        {"clean_users", "clean_groups", "clean_schedules", "add_groups", "add_users", "add_schedules"},
+	"backup":      {"make_backup_folder", "make_backup", "download_backup"},
+	"export":      {"make_backup_folder", "make_export", "download_backup"},
+	"rotate":      {"rotate_passwords"},
+	"credentials": {"sync_ssh_keys", "rotate_passwords", "sync_breakglass"},
+}
+
+func (host *THost) LoadJobs() error {
+	var err error
+	if len(host.Jobs) == 0 {
+		host.Jobs = TJobs{&TJob{
+			Name:     DefaultJobName,
+			TaskName: host.TaskName,
+			Profile:  host.Profile,
+			Sequence: host.Sequence,
+		}}
+	}
+	for _, job := range host.Jobs {
+		job.trigger = make(chan struct{}, 1)
+		if host.Jobs.Count(job.Name) > 1 {
+			return errors.New(fmt.Sprintf("[%s] job \"%s\" is defined more than once", host.IP, job.Name))
+		}
+		job.Task, err = Tasks.GetByName(job.TaskName)
+		if err != nil {
+			return err
+		}
+		if job.Profile != "" {
+			profile, err := Profiles.GetByName(job.Profile)
+			if err != nil {
+				return err
+			}
+			if len(job.Sequence) == 0 {
+				job.Sequence = profile.Sequence
+			}
+			if job.StepRetry == nil {
+				job.StepRetry = profile.StepRetry
+			}
+		}
+		if len(job.Sequence) == 0 {
+			job.Sequence = DefaultSequence
+		}
+		err = Steps.CheckSequence(job.Sequence)
+		if err != nil {
+			return errors.New(fmt.Sprintf("[%s] job \"%s\": %s", host.IP, job.Name, err))
+		}
+	}
+	return nil
+}
+
+func (jobs TJobs) GetByName(name string) (*TJob, error) {
+	for _, job := range jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	err := errors.New("job does not exist")
+	return &TJob{}, err
+}
+
+func (jobs TJobs) Count(name string) int {
+	var count int
+	for _, job := range jobs {
+		if job.Name == name {
+			count++
+		}
+	}
+	return count
+}
+
+func (host *THost) Run() {
+	var wg sync.WaitGroup
+	go host.WatchAlerts()
+	for _, job := range host.Jobs {
+		wg.Add(1)
+		go func(job *TJob) {
+			defer wg.Done()
+			host.RunJob(job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (host *THost) RunJob(job *TJob) {
+	for {
+		var next time.Time
+		stateMutex.Lock()
+		options := job.options
+		job.options = TRunOptions{}
+		stateMutex.Unlock()
+		err := host.StartJob(job, options)
+		if err != nil {
+			job.Failures++
+			retry := job.Task.GetRetry()
+			class := ClassifyError(err)
+			host.Log().Error("job error", "job", job.Name, "class", class, "error", err)
+			if class == ErrorPermanent || retry.IsExhausted(job.Failures) {
+				host.Log().Warn("job is not retried until the next scheduled run", "job", job.Name)
+				job.Failures = 0
+				next = job.GetNextTime()
+			} else {
+				next = time.Now().Add(retry.GetDelay(job.Failures))
+			}
+		} else {
+			job.Failures = 0
+			next = job.GetNextTime()
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-job.trigger:
+			timer.Stop()
+			host.Log().Info("job triggered", "job", job.Name)
+		}
+	}
+}
+
+func (host *THost) StartJob(job *TJob, options TRunOptions) error {
+	start := time.Now().Unix()
+	err := host.Execute(job.Name, job, job.Sequence, options)
+	host.SetJobResult(job, start, err)
+	return err
+}
+
+func (host *THost) Execute(name string, job *TJob, sequence TListOfStrings, options TRunOptions) error {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	lock, err := host.LockHost()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	MetricHostsInFlight.Add(1)
+	defer MetricHostsInFlight.Add(-1)
+	host.SetRunning(name)
+	defer host.SetRunning("")
+	host.job = job
+	host.options = options
+	host.rotated = nil
+	err = LoadGrants()
+	if err != nil {
+		host.Log().Error("grants error", "error", err)
+	}
+	users := host.GetConfiguredUsers(time.Now())
+	stateMutex.Lock()
+	host.Users = users
+	stateMutex.Unlock()
+	defer func() { host.job, host.options = nil, TRunOptions{} }()
+	host.BeginRun(name).ForceDeletions = options.ForceDeletions
+	host.Log().Info("starting job")
+	err = host.RunHooks(HookPreRun, nil)
+	if err == nil {
+		err = host.StartManager(sequence)
+	}
+	data := map[string]string{"status": StatusSuccess}
+	if err != nil {
+		data["status"] = StatusFailed
+		data["error"] = err.Error()
+	}
+	errHook := host.RunHooks(HookPostRun, data)
+	if err == nil {
+		err = errHook
+	}
+	host.EndRun(err)
+	return err
+}
+
+func (host *THost) RunAction(action string, options TRunOptions) error {
+	sequence, ok := Actions[action]
+	if !ok {
+		profile, err := Profiles.GetByName(action)
+		if err != nil {
+			return errors.New(fmt.Sprintf("action \"%s\" does not exist", action))
+		}
+		sequence = profile.Sequence
+	}
+	return host.Execute("manual:"+action, nil, sequence, options)
+}
+
+func (job *TJob) Trigger(options TRunOptions) bool {
+	if options.ForceDeletions {
+		stateMutex.Lock()
+		job.options = options
+		stateMutex.Unlock()
+	}
+	select {
+	case job.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (job *TJob) GetNextTime() time.Time {
+	return job.Task.Next(time.Now())
+}
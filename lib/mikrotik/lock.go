@@ -0,0 +1,49 @@
+package mikrotik
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// TFileLock serializes processes sharing a file, the daemon and CLI commands
+// started next to it. The lock is held on a separate ".lock" file, so the
+// protected file can still be replaced by rename.
+type TFileLock struct {
+	file *os.File
+}
+
+func LockFile(path string) (*TFileLock, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &TFileLock{file: file}, nil
+}
+
+// LockHost serializes changes to the device between the daemon and CLI
+// commands such as run and rotate-self. Without dir_locks the lock files are
+// kept in the temporary directory, which all processes of a user share.
+func (host *THost) LockHost() (*TFileLock, error) {
+	dir := Params.GetValue("dir_locks")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "rosman-locks")
+	}
+	return LockFile(filepath.Join(dir, "host."+host.IP))
+}
+
+func (lock *TFileLock) Unlock() {
+	if lock.file == nil {
+		return
+	}
+	_ = unlockFile(lock.file)
+	_ = lock.file.Close()
+}
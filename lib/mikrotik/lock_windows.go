@@ -0,0 +1,67 @@
+//go:build windows
+
+package mikrotik
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Windows has no flock in the standard library, a marker file created with
+// O_EXCL is used instead. The marker holds the PID of the owner and is taken
+// over only when that process is gone, a lock may be held for a whole run.
+// A marker without a PID is left by a crash right after creating it and is
+// taken over once older than lockStale.
+const lockStale = 5 * time.Minute
+
+func lockFile(file *os.File) error {
+	path := file.Name() + ".held"
+	for {
+		marker, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = marker.WriteString(strconv.Itoa(os.Getpid()))
+			if errClose := marker.Close(); err == nil {
+				err = errClose
+			}
+			if err != nil {
+				_ = os.Remove(path)
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if isMarkerStale(path) {
+			_ = os.Remove(path)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func isMarkerStale(path string) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		info, err := os.Stat(path)
+		return err == nil && time.Since(info.ModTime()) > lockStale
+	}
+	// FindProcess opens the process on Windows and fails when it has exited
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	_ = process.Release()
+	return false
+}
+
+func unlockFile(file *os.File) error {
+	return os.Remove(file.Name() + ".held")
+}
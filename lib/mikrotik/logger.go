@@ -0,0 +1,298 @@
+package mikrotik
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TLogLevel int
+
+const (
+	LevelDebug TLogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	LogFormatLogfmt = "logfmt"
+	LogFormatJSON   = "json"
+)
+
+type TLogger struct {
+	Level  *TLogLevel
+	Fields []interface{}
+}
+
+type TRotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	file       *os.File
+	size       int64
+}
+
+var logLevelNames = map[TLogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+var Log = &TLogger{}
+var logLevel = LevelInfo
+var logFormat = LogFormatLogfmt
+var logOutput io.Writer = os.Stderr
+var logMutex sync.Mutex
+
+// LogRotation is set by the service, only one process rotates the log file.
+// CLI commands started next to it append to the file as it is.
+var LogRotation bool
+
+func ParseLogLevel(name string) (TLogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if levelName == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, errors.New(fmt.Sprintf("log level \"%s\" does not exist", name))
+}
+
+func (level TLogLevel) String() string {
+	return logLevelNames[level]
+}
+
+func ConfigureLogging() error {
+	var err error
+	if param, errParam := Params.GetByName("log_level"); errParam == nil && param.Value != "" {
+		logLevel, err = ParseLogLevel(param.Value)
+		if err != nil {
+			return err
+		}
+	}
+	if param, errParam := Params.GetByName("log_format"); errParam == nil && param.Value != "" {
+		if param.Value != LogFormatLogfmt && param.Value != LogFormatJSON {
+			return errors.New(fmt.Sprintf("log format \"%s\" does not exist", param.Value))
+		}
+		logFormat = param.Value
+	}
+	param, err := Params.GetByName("log_file")
+	if err != nil || param.Value == "" {
+		return nil
+	}
+	file := &TRotatingFile{Path: param.Value, MaxSize: 10 << 20, MaxBackups: 5}
+	if maxSize, errParam := Params.GetByName("log_file_max_size"); errParam == nil && maxSize.Value != "" {
+		megabytes, err := strconv.ParseInt(maxSize.Value, 10, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid log_file_max_size \"%s\"", maxSize.Value))
+		}
+		file.MaxSize = megabytes << 20
+	}
+	if maxBackups, errParam := Params.GetByName("log_file_max_backups"); errParam == nil && maxBackups.Value != "" {
+		file.MaxBackups, err = strconv.Atoi(maxBackups.Value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid log_file_max_backups \"%s\"", maxBackups.Value))
+		}
+	}
+	if !LogRotation {
+		file.MaxSize = 0
+	}
+	err = file.open()
+	if err != nil {
+		return err
+	}
+	logMutex.Lock()
+	logOutput = file
+	logMutex.Unlock()
+	return nil
+}
+
+func (logger *TLogger) With(fields ...interface{}) *TLogger {
+	return &TLogger{
+		Level:  logger.Level,
+		Fields: append(append([]interface{}{}, logger.Fields...), fields...),
+	}
+}
+
+func (logger *TLogger) WithLevel(level TLogLevel) *TLogger {
+	return &TLogger{Level: &level, Fields: logger.Fields}
+}
+
+func (logger *TLogger) Enabled(level TLogLevel) bool {
+	if logger.Level != nil {
+		return level >= *logger.Level
+	}
+	return level >= logLevel
+}
+
+func (logger *TLogger) Debug(message string, fields ...interface{}) {
+	logger.write(LevelDebug, message, fields)
+}
+
+func (logger *TLogger) Info(message string, fields ...interface{}) {
+	logger.write(LevelInfo, message, fields)
+}
+
+func (logger *TLogger) Warn(message string, fields ...interface{}) {
+	logger.write(LevelWarn, message, fields)
+}
+
+func (logger *TLogger) Error(message string, fields ...interface{}) {
+	logger.write(LevelError, message, fields)
+}
+
+func (logger *TLogger) write(level TLogLevel, message string, fields []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+	record := []interface{}{
+		"time", time.Now().Format(time.RFC3339Nano),
+		"level", level.String(),
+		"msg", message,
+	}
+	record = append(append(record, logger.Fields...), fields...)
+	var line string
+	if logFormat == LogFormatJSON {
+		line = formatLogJSON(record)
+	} else {
+		line = formatLogfmt(record)
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	_, _ = io.WriteString(logOutput, line+"\n")
+}
+
+func formatLogValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case error:
+		return typed.Error()
+	case time.Duration:
+		return typed.String()
+	case time.Time:
+		return typed.Format(time.RFC3339)
+	case fmt.Stringer:
+		return typed.String()
+	case string, bool, int, int64, uint64, float64:
+		return typed
+	}
+	return fmt.Sprint(value)
+}
+
+func formatLogfmt(record []interface{}) string {
+	var builder strings.Builder
+	for i := 0; i+1 < len(record); i += 2 {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(fmt.Sprint(record[i]))
+		builder.WriteByte('=')
+		value := fmt.Sprint(formatLogValue(record[i+1]))
+		if value == "" || strings.ContainsAny(value, " =\"\t\r\n\\") {
+			value = strconv.Quote(value)
+		}
+		builder.WriteString(value)
+	}
+	return builder.String()
+}
+
+func formatLogJSON(record []interface{}) string {
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i := 0; i+1 < len(record); i += 2 {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(record[i]))
+		value, err := json.Marshal(formatLogValue(record[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(record[i+1]))
+		}
+		builder.Write(key)
+		builder.WriteByte(':')
+		builder.Write(value)
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+// Log returns the logger of the host with the job, run and step of the run in
+// progress. The context is kept apart from the run, so that API handlers and
+// other goroutines can log for the host while it runs.
+func (host *THost) Log() *TLogger {
+	logger := Log.With("host", host.Name, "ip", host.IP)
+	if host.logLevel != nil {
+		logger = logger.WithLevel(*host.logLevel)
+	}
+	host.logMutex.Lock()
+	context := host.logContext
+	host.logMutex.Unlock()
+	if len(context) > 0 {
+		logger = logger.With(context...)
+	}
+	return logger
+}
+
+func (host *THost) setLogContext(fields ...interface{}) {
+	host.logMutex.Lock()
+	host.logContext = fields
+	host.logMutex.Unlock()
+}
+
+func (file *TRotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(file.Path), 0755)
+	if err != nil {
+		return err
+	}
+	file.file, err = os.OpenFile(file.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.file.Stat()
+	if err != nil {
+		return err
+	}
+	file.size = info.Size()
+	return nil
+}
+
+func (file *TRotatingFile) rotate() error {
+	err := file.file.Close()
+	if err != nil {
+		return err
+	}
+	for i := file.MaxBackups; i > 0; i-- {
+		src := file.Path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", file.Path, i-1)
+		}
+		if _, err := os.Stat(src); err == nil {
+			_ = os.Rename(src, fmt.Sprintf("%s.%d", file.Path, i))
+		}
+	}
+	if file.MaxBackups <= 0 {
+		_ = os.Remove(file.Path)
+	}
+	return file.open()
+}
+
+func (file *TRotatingFile) Write(content []byte) (int, error) {
+	if file.MaxSize > 0 && file.size+int64(len(content)) > file.MaxSize && file.size > 0 {
+		err := file.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(content)
+	file.size += int64(n)
+	return n, err
+}
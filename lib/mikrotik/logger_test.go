@@ -0,0 +1,31 @@
+package mikrotik
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogRotationOnlyInService(t *testing.T) {
+	for _, rotation := range []bool{false, true} {
+		dir := useParams(t, map[string]string{"log_file": "rosman.log"})
+		Params = append(Params, &TParam{Name: "log_file_max_size", Value: "1"})
+		path := filepath.Join(dir, "rosman.log")
+		if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 1<<20)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		savedRotation, savedOutput := LogRotation, logOutput
+		LogRotation = rotation
+		if err := ConfigureLogging(); err != nil {
+			t.Fatal(err)
+		}
+		Log.Info("line")
+		_ = logOutput.(*TRotatingFile).file.Close()
+		LogRotation, logOutput = savedRotation, savedOutput
+		if _, err := os.Stat(path + ".1"); (err == nil) != rotation {
+			t.Errorf("rotation %v: unexpected backup file: %v", rotation, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package mikrotik
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TMetricFamilies []*TMetricFamily
+type TMetricFamily struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+	series map[string]*tMetricSeries
+}
+
+type tMetricSeries struct {
+	labels []string
+	value  float64
+	sum    float64
+	count  uint64
+}
+
+const (
+	MetricCounter = "counter"
+	MetricGauge   = "gauge"
+	MetricSummary = "summary"
+)
+
+var metricsMutex sync.Mutex
+
+var (
+	MetricRuns             = NewMetric("rosman_runs_total", "Number of finished job runs.", MetricCounter, "host", "job", "status")
+	MetricRunDuration      = NewMetric("rosman_run_duration_seconds", "Duration of job runs.", MetricSummary, "host", "job")
+	MetricSteps            = NewMetric("rosman_steps_total", "Number of finished steps.", MetricCounter, "host", "step", "status")
+	MetricStepDuration     = NewMetric("rosman_step_duration_seconds", "Duration of steps.", MetricSummary, "host", "step")
+	MetricLastSeen         = NewMetric("rosman_last_success_timestamp_seconds", "Unix time of the last successful connection to the host.", MetricGauge, "host")
+	MetricJobLastSuccess   = NewMetric("rosman_job_last_success_timestamp_seconds", "Unix time of the last successful job run.", MetricGauge, "host", "job")
+	MetricObjectsCreated   = NewMetric("rosman_objects_created_total", "Number of users, groups and schedules created on devices.", MetricCounter, "host", "kind")
+	MetricObjectsRemoved   = NewMetric("rosman_objects_removed_total", "Number of users, groups and schedules removed from devices.", MetricCounter, "host", "kind")
+	MetricObjectsDisabled  = NewMetric("rosman_objects_disabled_total", "Number of unknown users disabled on devices.", MetricCounter, "host", "kind")
+	MetricDownloadedBytes  = NewMetric("rosman_downloaded_bytes_total", "Number of bytes downloaded from devices.", MetricCounter, "host")
+	MetricConnectionErrors = NewMetric("rosman_connection_errors_total", "Number of failed connections by transport.", MetricCounter, "host", "transport")
+	MetricHostsInFlight    = NewMetric("rosman_hosts_in_flight", "Number of hosts with a job currently running.", MetricGauge)
+	Metrics                = TMetricFamilies{
+		MetricRuns,
+		MetricRunDuration,
+		MetricSteps,
+		MetricStepDuration,
+		MetricLastSeen,
+		MetricJobLastSuccess,
+		MetricObjectsCreated,
+		MetricObjectsRemoved,
+		MetricObjectsDisabled,
+		MetricDownloadedBytes,
+		MetricConnectionErrors,
+		MetricHostsInFlight,
+	}
+)
+
+func NewMetric(name string, help string, metricType string, labels ...string) *TMetricFamily {
+	return &TMetricFamily{Name: name, Help: help, Type: metricType, Labels: labels, series: map[string]*tMetricSeries{}}
+}
+
+func (family *TMetricFamily) get(labels []string) *tMetricSeries {
+	key := strings.Join(labels, "\xff")
+	series, ok := family.series[key]
+	if !ok {
+		series = &tMetricSeries{labels: labels}
+		family.series[key] = series
+	}
+	return series
+}
+
+func (family *TMetricFamily) Add(value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	family.get(labels).value += value
+}
+
+func (family *TMetricFamily) Set(value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	family.get(labels).value = value
+}
+
+func (family *TMetricFamily) Observe(value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	series := family.get(labels)
+	series.sum += value
+	series.count++
+}
+
+func (families TMetricFamilies) Write(writer io.Writer) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	for _, family := range families {
+		_, err := fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", family.Name, family.Help, family.Name, family.Type)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			labels := family.formatLabels(series.labels)
+			if family.Type == MetricSummary {
+				_, err = fmt.Fprintf(writer, "%s_sum%s %s\n%s_count%s %d\n", family.Name, labels, formatMetricValue(series.sum), family.Name, labels, series.count)
+			} else {
+				_, err = fmt.Fprintf(writer, "%s%s %s\n", family.Name, labels, formatMetricValue(series.value))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (family *TMetricFamily) formatLabels(values []string) string {
+	if len(family.Labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for i, name := range family.Labels {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func UpdateStateMetrics() {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	for _, host := range Hosts {
+		if host.LastSeen > 0 {
+			MetricLastSeen.Set(float64(host.LastSeen), host.Name)
+		}
+		for _, job := range host.Jobs {
+			if job.LastSuccess > 0 {
+				MetricJobLastSuccess.Set(float64(job.LastSuccess), host.Name, job.Name)
+			}
+		}
+	}
+}
+
+func (run *TRun) ObserveMetrics() {
+	MetricRuns.Add(1, run.Host, run.Job, run.Status)
+	MetricRunDuration.Observe(run.End.Sub(run.Start).Seconds(), run.Host, run.Job)
+}
+
+func (step *TStepResult) ObserveMetrics(host *THost) {
+	MetricSteps.Add(1, host.Name, step.Name, step.Status)
+	MetricStepDuration.Observe(step.End.Sub(step.Start).Seconds(), host.Name, step.Name)
+}
+
+func MetricsHandler(writer http.ResponseWriter, request *http.Request) {
+	UpdateStateMetrics()
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := Metrics.Write(writer)
+	if err != nil {
+		Log.Error("metrics error", "component", "metrics", "error", err)
+	}
+}
+
+func StartMetricsServer() {
+	param, err := Params.GetByName("metrics_listen")
+	if err != nil || param.Value == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", MetricsHandler)
+	server := &http.Server{Addr: param.Value, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	Log.Info("metrics listener started", "component", "metrics", "listen", param.Value)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil {
+			Log.Error("metrics error", "component", "metrics", "error", err)
+		}
+	}()
+}
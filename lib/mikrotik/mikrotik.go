@@ -9,11 +9,10 @@ import (
 	"gopkg.in/routeros.v2"
 	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,40 +27,70 @@ type TParam struct {
 
 type THosts []*THost
 type THost struct {
-	Name             string         `json:"name"`
-	IP               string         `json:"ip"`
-	Login            string         `json:"login"`
-	Pass             string         `json:"pass"`
-	PortAPI          int            `json:"port_api"`
-	PortSSH          int            `json:"port_ssh"`
-	BackupFolder     string         `json:"backup_folder"`
-	TaskName         string         `json:"task_name"`
-	UsersAliases     TListOfStrings `json:"users_aliases"`
-	SchedulesAliases TListOfStrings `json:"schedules_aliases"`
-	UsersAllowed     TListOfStrings `json:"users_allowed"`
-	LastSeen         int64
-	Task             *TTask
-	Users            TUsers
-	Groups           TGroups
-	Schedules        TSchedules
-	connections      tConnections
+	Name               string         `json:"name"`
+	IP                 string         `json:"ip"`
+	Login              string         `json:"login"`
+	Pass               string         `json:"pass"`
+	PortAPI            int            `json:"port_api"`
+	PortSSH            int            `json:"port_ssh"`
+	BackupFolder       string         `json:"backup_folder"`
+	TaskName           string         `json:"task_name"`
+	Profile            string         `json:"profile"`
+	Sequence           TListOfStrings `json:"sequence"`
+	UsersAliases       TListOfStrings `json:"users_aliases"`
+	SchedulesAliases   TListOfStrings `json:"schedules_aliases"`
+	UsersAllowed       TListOfStrings `json:"users_allowed"`
+	Jobs               TJobs          `json:"jobs"`
+	LogLevel           string         `json:"log_level"`
+	ProtectedUsers     TListOfStrings `json:"protected_users"`
+	ProtectedGroups    TListOfStrings `json:"protected_groups"`
+	ProtectedSchedules TListOfStrings `json:"protected_schedules"`
+	MaxDeletions       *int           `json:"max_deletions"`
+	UnknownUsers       string         `json:"unknown_users"`
+	QuarantineDays     *int           `json:"quarantine_days"`
+	RouterOS           int            `json:"routeros"`
+	Site               string         `json:"site"`
+	LastSeen           int64
+	Users              TUsers
+	Groups             TGroups
+	Schedules          TSchedules
+	connections        tConnections
+	mutex              sync.Mutex
+	job                *TJob
+	run                *TRun
+	logLevel           *TLogLevel
+	logMutex           sync.Mutex
+	logContext         []interface{}
+	running            string
+	options            TRunOptions
+	stepRetry          *TRetry
+	drift              *TDrift
+	rotated            map[string]bool
+	driftError         string
+	unreachable        bool
+	passwords          map[string]*TPasswordState
 }
 
 type tConnections struct {
-	ssh  *ssh.Client
-	sftp *sftp.Client
-	api  *routeros.Client
+	ssh      *ssh.Client
+	sftp     *sftp.Client
+	api      *routeros.Client
+	routerOS int
 }
 
 type TUsers []*TUser
 type TUser struct {
-	Login   string `json:"login"`
-	Pass    string `json:"pass"`
-	Group   string `json:"group"`
-	Address string `json:"address"`
-	Comment string `json:"comment"`
-	Alias   string `json:"alias"`
-	Key     string `json:"key"`
+	Login      string         `json:"login"`
+	Pass       string         `json:"pass"`
+	Group      string         `json:"group"`
+	Address    string         `json:"address"`
+	Comment    string         `json:"comment"`
+	Alias      string         `json:"alias"`
+	Key        string         `json:"key"`
+	Keys       TListOfStrings `json:"keys"`
+	RotateDays int            `json:"rotate_days"`
+	sshKeys    []*TSshKey
+	disabled   bool
 }
 
 type TSchedules []*TSchedule
@@ -75,25 +104,34 @@ type TSchedule struct {
 	Comment   string `json:"comment"`
 	Script    string `json:"script"`
 	Alias     string `json:"alias"`
-	OnEvent   string
+	OnEvent   string `json:"-"`
 }
 
 type TTasks []*TTask
 type TTask struct {
-	Name    string `json:"name"`
-	Start   int64  `json:"start"`
-	Delay   int64  `json:"delay"`
-	Expired int64  `json:"expired"`
-	Alert   int64  `json:"alert"`
-	Note    string `json:"note"`
+	Name     string  `json:"name"`
+	Cron     string  `json:"cron"`
+	TimeZone string  `json:"timezone"`
+	Start    int64   `json:"start"`
+	Delay    int64   `json:"delay"`
+	Expired  int64   `json:"expired"`
+	Alert    int64   `json:"alert"`
+	Retry    *TRetry `json:"retry"`
+	Note     string  `json:"note"`
+	cron     *TCron
+	location *time.Location
 }
 
 type TGroups []*TGroup
 type TGroup struct {
-	Name    string `json:"name"`
-	Skin    string `json:"skin"`
-	Comment string `json:"comment"`
-	Policy  string `json:"policy"`
+	Name    string         `json:"name"`
+	Skin    string         `json:"skin"`
+	Comment string         `json:"comment"`
+	Policy  string         `json:"policy,omitempty"`
+	Extends string         `json:"extends,omitempty"`
+	Allow   TListOfStrings `json:"allow,omitempty"`
+	Deny    TListOfStrings `json:"deny,omitempty"`
+	policy  TListOfStrings
 }
 
 var Params TParams
@@ -102,17 +140,23 @@ var Tasks TTasks
 var Users TUsers
 var Groups TGroups
 var Schedules TSchedules
+var Profiles TProfiles
 var cfgMain = "configs/main.json"
 
-func init() {
-	err := LoadConfig()
+// LoadParams reads configs/main.json relative to the working directory and
+// configures logging.
+func LoadParams() error {
+	err := LoadJSON(&Params, cfgMain)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+	return ConfigureLogging()
 }
 
+// LoadConfig reads main.json and every file it points to. It is called by
+// main before anything else.
 func LoadConfig() error {
-	err := LoadJSON(&Params, cfgMain)
+	err := LoadParams()
 	if err != nil {
 		return err
 	}
@@ -125,145 +169,228 @@ func LoadConfig() error {
 	if err != nil {
 		return err
 	}
+	err = Tasks.Load()
+	if err != nil {
+		return err
+	}
+	err = LoadConfigVersion(dirCfg.Value)
+	if err != nil {
+		return err
+	}
+	err = LoadJSON(&Networks, dirCfg.Value+"networks.json")
+	if err != nil {
+		return err
+	}
+	err = Networks.Load()
+	if err != nil {
+		return err
+	}
 	err = LoadJSON(&Users, dirCfg.Value+"users.json")
 	if err != nil {
 		return err
 	}
+	err = Users.LoadKeys()
+	if err != nil {
+		return err
+	}
 	err = LoadJSON(&Groups, dirCfg.Value+"groups.json")
 	if err != nil {
 		return err
 	}
+	err = Groups.Load()
+	if err != nil {
+		return err
+	}
 	err = LoadJSON(&Schedules, dirCfg.Value+"schedules.json")
 	if err != nil {
 		return err
 	}
-	err = Schedules.LoadOnEventScripts()
+	err = Schedules.LoadPolicies()
 	if err != nil {
 		return err
 	}
-	for _, host := range Hosts {
-		host.Users = Users.FilterByAliases(host.UsersAliases)
-		host.Schedules = Schedules.FilterByAliases(host.SchedulesAliases)
-		host.Groups = Groups
-		host.Task, err = Tasks.GetByName(host.TaskName)
-		if err != nil {
-			return err
-		}
+	err = LoadJSON(&Profiles, dirCfg.Value+"profiles.json")
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func LoadJSON(variable interface{}, jsonPath string) error {
-	var err error
-	var jsonFile *os.File
-	var jsonByte []byte
-	jsonFile, err = os.Open(jsonPath)
+	err = Profiles.Load()
 	if err != nil {
 		return err
 	}
-	jsonByte, err = ioutil.ReadAll(jsonFile)
+	err = LoadJSON(&Webhooks, dirCfg.Value+"webhooks.json")
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(jsonByte, &variable)
+	err = Webhooks.Load()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[INIT] Config \"%s\" loaded...", jsonFile.Name()))
-	err = jsonFile.Close()
+	err = LoadJSON(&SMTP, dirCfg.Value+"smtp.json")
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (host *THost) Run() {
-	var delay int64
-	err := host.StartManager()
+	err = SMTP.Load()
 	if err != nil {
-		log.Println(fmt.Sprintf("[%s] manager error: \"%s\"", host.IP, err))
-		delay = host.Task.Expired
-	} else {
-		delay = host.GetNextTime() - time.Now().Unix()
+		return err
 	}
-	time.Sleep(time.Duration(delay) * time.Second)
-	host.Run()
-	return
-}
-
-func (host *THost) StartManager() error {
-	var dir, err = Params.GetByName("dir_backup")
+	err = LoadJSON(&PasswordPolicy, dirCfg.Value+"passwords.json")
 	if err != nil {
 		return err
 	}
-	dir.Value = strings.Replace(dir.Value, "{host.name}", host.Name, -1)
-	dir.Value = strings.Replace(dir.Value, "{host.ip}", host.IP, -1)
-
-	log.Println(fmt.Sprintf("[%s] sequence for cleaning users", host.IP))
-	err = host.CleanUsers()
+	err = PasswordPolicy.Load()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for cleaning groups", host.IP))
-	err = host.CleanGroups()
+	err = LoadJSON(&Breakglass, dirCfg.Value+"breakglass.json")
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for cleaning schedules", host.IP))
-	err = host.CleanSchedules()
+	err = Breakglass.Load()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for adding groups", host.IP))
-	err = host.AddGroups()
+	err = CheckAddresses()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for adding users", host.IP))
-	err = host.AddUsers()
+	err = CheckSecretsStore()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for adding backup folder", host.IP))
-	err = host.MakeBackupFolder()
+	err = LoadJSON(&Safeguards, dirCfg.Value+"safeguards.json")
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for adding schedules", host.IP))
-	err = host.AddSchedules()
+	err = Safeguards.Load()
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("[%s] sequence for backup directory", host.IP))
-	err = host.DownloadFolder(host.BackupFolder, dir.Value, true)
+	err = LoadJSON(&Hooks, dirCfg.Value+"hooks.json")
+	if err != nil {
+		return err
+	}
+	err = Hooks.Load()
+	if err != nil {
+		return err
+	}
+	err = Schedules.LoadOnEventScripts()
+	if err != nil {
+		return err
+	}
+	err = LoadGrants()
+	if err != nil {
+		return err
+	}
+	for _, host := range Hosts {
+		host.Users = host.GetConfiguredUsers(time.Now())
+		host.Schedules = Schedules.FilterByAliases(host.SchedulesAliases)
+		host.Groups = Groups
+		if _, ok := GroupPolicies[host.RouterOS]; host.RouterOS != 0 && !ok {
+			return errors.New(fmt.Sprintf("[%s] routeros version %d is not supported", host.IP, host.RouterOS))
+		}
+		for _, group := range host.Groups {
+			err = group.CheckVersion(host)
+			if err != nil {
+				return err
+			}
+		}
+		err = host.LoadJobs()
+		if err != nil {
+			return err
+		}
+		if host.LogLevel != "" {
+			level, err := ParseLogLevel(host.LogLevel)
+			if err != nil {
+				return err
+			}
+			host.logLevel = &level
+		}
+	}
+	return LoadState()
+}
+
+func LoadJSON(variable interface{}, jsonPath string) error {
+	var err error
+	var jsonFile *os.File
+	var jsonByte []byte
+	jsonFile, err = os.Open(jsonPath)
+	if err != nil {
+		return err
+	}
+	jsonByte, err = ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(jsonByte, &variable)
+	if err != nil {
+		return err
+	}
+	Log.Info("config loaded", "file", jsonFile.Name())
+	err = jsonFile.Close()
 	if err != nil {
 		return err
 	}
-	host.Disconnect()
 	return nil
 }
 
-func (host *THost) GetNextTime() int64 {
-	var now = time.Now().Unix()
-	var start = host.Task.Start
-	var delay = host.Task.Delay
-	next := (int64((now-start)/delay)+1)*delay + start
-	return next
+func (host *THost) StartManager(sequence TListOfStrings) error {
+	defer host.Disconnect()
+	return host.RunSequence(sequence)
 }
 
 func (host *THost) CleanUsers() error {
 	var err error
+	var remove TListOfStrings
+	reasons := map[string]string{}
+	policy := host.GetUnknownUsersPolicy()
 	usersPass := host.GetUsersAllowed()
 	users, err := host.GetUsers()
 	if err != nil {
 		return err
 	}
 	for _, user := range users {
-		if !usersPass.IsContain(user.Login) {
-			err = host.RemoveUser(user.Login)
-			if err != nil {
-				return err
+		since, _, quarantined := ParseQuarantineComment(user.Comment)
+		if usersPass.IsContain(user.Login) {
+			if quarantined {
+				err = host.ReleaseUser(user)
+				if err != nil {
+					return err
+				}
 			}
+			continue
+		}
+		grant := host.GetEndedGrant(user.Login, time.Now())
+		switch {
+		case grant != nil:
+			remove = append(remove, user.Login)
+			reasons[user.Login] = fmt.Sprintf("grant %s %s", grant.ID, grant.GetStatus(time.Now()))
+		case policy == UnknownUsersReport:
+			host.ReportUnknownUser(user)
+		case policy == UnknownUsersDelete:
+			remove = append(remove, user.Login)
+			reasons[user.Login] = "not in config"
+		case !quarantined:
+			remove = append(remove, user.Login)
+		case time.Since(since) >= host.GetQuarantinePeriod():
+			remove = append(remove, user.Login)
+			reasons[user.Login] = "quarantine expired"
+		}
+	}
+	remove, err = host.FilterDeletions("user", remove)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if !remove.IsContain(user.Login) {
+			continue
+		}
+		if reason, ok := reasons[user.Login]; ok {
+			err = host.RemoveUser(user.Login, reason)
+		} else {
+			err = host.DisableUser(user)
+		}
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -271,16 +398,24 @@ func (host *THost) CleanUsers() error {
 
 func (host *THost) CleanGroups() error {
 	var err error
+	var remove TListOfStrings
 	groups, err := host.GetGroups()
 	if err != nil {
 		return err
 	}
 	for _, group := range groups {
 		if !host.Groups.IsContain(group.Name) {
-			err = host.RemoveGroup(group.Name)
-			if err != nil {
-				return err
-			}
+			remove = append(remove, group.Name)
+		}
+	}
+	remove, err = host.FilterDeletions("group", remove)
+	if err != nil {
+		return err
+	}
+	for _, group := range remove {
+		err = host.RemoveGroup(group, "not in config")
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -288,53 +423,71 @@ func (host *THost) CleanGroups() error {
 
 func (host *THost) CleanSchedules() error {
 	var err error
+	var remove TListOfStrings
 	schedules, err := host.GetSchedules()
 	if err != nil {
 		return err
 	}
 	for _, schedule := range schedules {
 		if !host.Schedules.IsContain(schedule.Name) {
-			err = host.RemoveSchedule(schedule.Name)
-			if err != nil {
-				return err
-			}
+			remove = append(remove, schedule.Name)
+		}
+	}
+	remove, err = host.FilterDeletions("schedule", remove)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range remove {
+		err = host.RemoveSchedule(schedule, "not in config")
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// AddUsers creates missing users and keeps the address of existing ones.
 func (host *THost) AddUsers() error {
+	usersInside, err := host.GetUsers()
+	if err != nil {
+		return err
+	}
 	for _, user := range host.Users {
-		if host.IsContainUser(*user) {
-			log.Println(fmt.Sprintf("[%s] host already contain user \"%s\"", host.IP, user.Login))
+		if userInside := TUsers(usersInside).GetByLogin(user.Login); userInside != nil {
+			host.Log().Debug("host already contain user", "user", user.Login)
+			err = host.SyncUserAddress(userInside, user.Address)
+			if err != nil {
+				return err
+			}
 			continue
 		}
-		err := host.MakeUser(*user)
+		err = host.MakeUser(*user)
 		if err != nil {
-			log.Println(fmt.Sprintf("[%s] error: \"%s\"", host.IP, err))
+			host.Log().Error("adding user failed", "user", user.Login, "error", err)
 			continue
 		}
-		if user.Key != "" {
-			err = host.UploadKey(user.Key)
-			if err != nil {
-				return err
-			}
-			err = host.ImportSshKey(*user, 5000, 10)
-			if err != nil {
-				return err
-			}
+		err = host.AddUserKeys(user)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// AddGroups creates missing groups and sets the policy of existing ones when
+// it differs from the one rendered for the host.
 func (host *THost) AddGroups() error {
+	groupsInside, err := host.GetGroups()
+	if err != nil {
+		return err
+	}
 	for _, group := range host.Groups {
-		if host.IsContainGroup(*group) {
-			log.Println(fmt.Sprintf("[%s] host already contain group \"%s\"", host.IP, group.Name))
-			continue
+		groupInside := groupsInside.GetByName(group.Name)
+		if groupInside == nil {
+			err = host.MakeGroup(*group)
+		} else {
+			err = host.SyncGroupPolicy(group, groupInside)
 		}
-		err := host.MakeGroup(*group)
 		if err != nil {
 			return err
 		}
@@ -345,7 +498,7 @@ func (host *THost) AddGroups() error {
 func (host *THost) AddSchedules() error {
 	for _, schedule := range host.Schedules {
 		if host.IsContainSchedule(schedule) {
-			log.Println(fmt.Sprintf("[%s] host already contain schedule \"%s\"", host.IP, schedule.Name))
+			host.Log().Debug("host already contain schedule", "schedule", schedule.Name)
 			continue
 		}
 		err := host.MakeSchedule(schedule)
@@ -356,88 +509,134 @@ func (host *THost) AddSchedules() error {
 	return nil
 }
 
-func (host *THost) ImportSshKey(user TUser, delay time.Duration, attempts int) error {
-	log.Println(fmt.Sprintf("[%s] try import key \"%s\" for user \"%s\"", host.IP, user.Key, user.Login))
-	for i := 1; i <= attempts; i++ {
-		connApi, err := host.GetConnectionAPI()
-		if err != nil {
-			return err
+func (host *THost) ImportSshKey(login string, file string) error {
+	host.Log().Info("try import key", "key", file, "user", login)
+	// RouterOS lists an uploaded file a few seconds after the upload, an
+	// import before that fails
+	err := host.Retry(host.GetRetry("import_ssh_key"), "wait_for_key_file", func() error {
+		res, err := host.RunAPI("/file/print", "?name="+file)
+		if err == nil && len(res.Re) == 0 {
+			err = errors.New(fmt.Sprintf("file \"%s\" is not listed yet", file))
 		}
-		time.Sleep(delay * time.Millisecond)
-		_, err = connApi.Run("/user/ssh-keys/import", "=public-key-file="+user.Key, "=user="+user.Login)
-		if err != nil {
-			log.Println(fmt.Sprintf("[%s] [%s] error: \"%s\"", host.IP, user.Login, err.Error()))
-			log.Println(fmt.Sprintf("[%s] %d try and %d milisecond later", host.IP, i, i*int(delay)))
-			continue
-		}
-		log.Println(fmt.Sprintf("[%s] key \"%s\" imported for user \"%s\"", host.IP, user.Key, user.Login))
-		return nil
+		return err
+	})
+	if err != nil {
+		return err
 	}
-	err := errors.New("CmdImportSshKeys: all attempts used")
-	return err
+	err = host.Retry(host.GetRetry("import_ssh_key"), "import_ssh_key", func() error {
+		_, err := host.RunAPI("/user/ssh-keys/import", "=public-key-file="+file, "=user="+login)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	host.Log().Info("key imported", "key", file, "user", login)
+	return nil
 }
 
 func (host *THost) MakeUser(user TUser) error {
-	log.Println(fmt.Sprintf("[%s] adding user \"%s\"", host.IP, user.Login))
-	if user.Pass == "" {
-		user.GeneratePassword(512)
-		log.Println(fmt.Sprintf("[%s] user \"%s\" password is empty and has been generated", host.IP, user.Login))
+	host.Log().Info("adding user", "user", user.Login)
+	version := 0
+	if host.IsRotated(&user) {
+		password, target, err := GetRotationTarget(&user)
+		if err != nil {
+			return err
+		}
+		user.Pass, version = password, target
+	} else if user.Pass == "" {
+		err := user.GeneratePassword()
+		if err != nil {
+			return err
+		}
+		host.Log().Info("password is empty and has been generated", "user", user.Login)
+		err = host.EscrowPassword(user.Login, user.Pass)
+		if err != nil {
+			return err
+		}
 	}
-	connApi, err := host.GetConnectionAPI()
+	address, err := host.ExpandAddress(user.Address)
 	if err != nil {
 		return err
 	}
-	_, err = connApi.Run("/user/add", "=name="+user.Login, "=password="+user.Pass, "=group="+user.Group, "=address="+user.Address, "=comment="+user.Comment, "=disabled=no")
+	_, err = host.RunAPI("/user/add", "=name="+user.Login, "=password="+user.Pass, "=group="+user.Group, "=address="+address, "=comment="+user.Comment, "=disabled=no")
 	if err != nil {
 		return err
 	}
+	host.RecordCreated("user", user.Login)
+	if version > 0 {
+		host.SetPasswordState(user.Login, version, nil)
+	}
 	return nil
 }
 
 func (host *THost) MakeGroup(group TGroup) error {
-	log.Println(fmt.Sprintf("[%s] adding group \"%s\"", host.IP, group.Name))
-	connApi, err := host.GetConnectionAPI()
+	host.Log().Info("adding group", "group", group.Name)
+	policy, err := host.RenderGroupPolicy(&group)
 	if err != nil {
 		return err
 	}
-	_, err = connApi.Run("/user/group/add", "=name="+group.Name, "=skin="+group.Skin, "=comment="+group.Comment, "=policy="+group.Policy)
+	_, err = host.RunAPI("/user/group/add", "=name="+group.Name, "=skin="+group.Skin, "=comment="+group.Comment, "=policy="+policy)
 	if err != nil {
 		return err
 	}
+	host.RecordCreated("group", group.Name)
 	return nil
 }
 
-func (host *THost) MakeSchedule(schedule *TSchedule) error {
-	log.Println(fmt.Sprintf("[%s] adding schedule \"%s\"", host.IP, schedule.Name))
-	connApi, err := host.GetConnectionAPI()
+func (host *THost) SyncGroupPolicy(group *TGroup, groupInside *TGroup) error {
+	policy, err := host.RenderGroupPolicy(group)
 	if err != nil {
 		return err
 	}
-	_, err = connApi.Run("/system/scheduler/add",
+	if IsPolicyEqual(groupInside.Policy, policy) {
+		host.Log().Debug("host already contain group", "group", group.Name)
+		return nil
+	}
+	host.Log().Info("updating group policy", "group", group.Name, "policy", policy)
+	_, err = host.RunAPI("/user/group/set", "=numbers="+group.Name, "=policy="+policy)
+	if err != nil {
+		return err
+	}
+	host.RecordUpdated("group", group.Name, "policy")
+	return nil
+}
+
+// RenderGroupPolicy renders the policy for the version running on the device,
+// which may differ from the one the group was checked against on load.
+func (host *THost) RenderGroupPolicy(group *TGroup) (string, error) {
+	version := host.GetRouterOS()
+	policy, unsupported := group.RenderPolicy(version)
+	if len(unsupported) > 0 {
+		return "", &TPermanentError{Err: errors.New(fmt.Sprintf("group \"%s\": policies %s are not supported by RouterOS %d of the device, set routeros in hosts.json",
+			group.Name, strings.Join(unsupported, ","), version))}
+	}
+	return policy, nil
+}
+
+func (host *THost) MakeSchedule(schedule *TSchedule) error {
+	host.Log().Info("adding schedule", "schedule", schedule.Name)
+	_, err := host.RunAPI("/system/scheduler/add",
 		"=name="+schedule.Name,
 		"=disabled="+schedule.Disabled,
 		"=start-date="+schedule.StartDate,
 		"=start-time="+schedule.StartTime,
 		"=interval="+schedule.Interval,
-		"=policy="+schedule.Policy,
+		"=policy="+schedule.RenderPolicy(),
 		"=comment="+schedule.Comment,
 		"=on-event="+schedule.OnEvent,
 	)
 	if err != nil {
-		log.Println(err)
+		host.Log().Error("adding schedule failed", "schedule", schedule.Name, "error", err)
 		return err
 	}
+	host.RecordCreated("schedule", schedule.Name)
 	return nil
 }
 
 func (host *THost) GetUsers() ([]*TUser, error) {
 	var err error
 	var users []*TUser
-	connApi, err := host.GetConnectionAPI()
-	if err != nil {
-		return []*TUser{}, err
-	}
-	res, err := connApi.Run("/user/print")
+	res, err := host.RunAPI("/user/print")
 	if err != nil {
 		return []*TUser{}, err
 	}
@@ -448,6 +647,7 @@ func (host *THost) GetUsers() ([]*TUser, error) {
 			Address: el.Map["address"],
 			Group:   el.Map["group"],
 		}
+		user.disabled = el.Map["disabled"] == "true"
 		users = append(users, &user)
 	}
 	return users, nil
@@ -456,17 +656,14 @@ func (host *THost) GetUsers() ([]*TUser, error) {
 func (host *THost) GetSchedules() ([]*TSchedule, error) {
 	var err error
 	var schedules []*TSchedule
-	connApi, err := host.GetConnectionAPI()
-	if err != nil {
-		return []*TSchedule{}, err
-	}
-	res, err := connApi.Run("/system/scheduler/print")
+	res, err := host.RunAPI("/system/scheduler/print")
 	if err != nil {
 		return []*TSchedule{}, err
 	}
 	for _, el := range res.Re {
 		schedule := TSchedule{
 			Name:      el.Map["name"],
+			Disabled:  el.Map["disabled"],
 			StartDate: el.Map["start-date"],
 			StartTime: el.Map["start-time"],
 			Interval:  el.Map["interval"],
@@ -481,11 +678,7 @@ func (host *THost) GetSchedules() ([]*TSchedule, error) {
 
 func (host *THost) GetGroups() (TGroups, error) {
 	var groups TGroups
-	connApi, err := host.GetConnectionAPI()
-	if err != nil {
-		return TGroups{}, err
-	}
-	res, err := connApi.Run("/user/group/print")
+	res, err := host.RunAPI("/user/group/print")
 	if err != nil {
 		return TGroups{}, err
 	}
@@ -496,69 +689,79 @@ func (host *THost) GetGroups() (TGroups, error) {
 	return groups, nil
 }
 
-func (host *THost) UploadKey(key string) error {
-	log.Println(fmt.Sprintf("[%s] uploading key \"%s\"", host.IP, key))
+func (host *THost) UploadKey(name string, content string) error {
+	host.Log().Info("uploading key", "key", name)
 	connSftp, err := host.GetConnectionSFTP()
 	if err != nil {
 		return err
 	}
-	fileDst, err := connSftp.Create(key)
+	fileDst, err := connSftp.Create(name)
+	host.RecordAudit("/sftp/create", []string{"=path=" + name}, err)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = fileDst.Close }()
-	dirKeys, err := Params.GetByName("dir_ssh-pub-keys")
+	_, err = fileDst.Write([]byte(content))
 	if err != nil {
+		_ = fileDst.Close()
 		return err
 	}
-	fileSrc, err := os.Open(dirKeys.Value + key)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = fileSrc.Close }()
-	_, err = io.Copy(fileDst, fileSrc)
-	if err != nil {
-		return err
-	}
-	return nil
+	return fileDst.Close()
 }
 
-func (host *THost) RemoveUser(user string) error {
-	connApi, err := host.GetConnectionAPI()
+func (host *THost) RemoveUser(user string, reason string) error {
+	if host.IsProtected("user", user) {
+		host.Log().Warn("protected user is not removed", "user", user, "reason", reason)
+		return nil
+	}
+	err := host.RunHooks(HookOnDeletion, map[string]string{"kind": "user", "name": user, "reason": reason})
 	if err != nil {
-		return err
+		host.Log().Warn("deletion skipped", "user", user, "error", err)
+		return nil
 	}
-	log.Println(fmt.Sprintf("[%s] delete user \"%s\"", host.IP, user))
-	_, err = connApi.Run("/user/remove", "=numbers="+user)
+	host.Log().Info("delete user", "user", user, "reason", reason)
+	_, err = host.RunAPI("/user/remove", "=numbers="+user)
 	if err != nil {
 		return err
 	}
+	host.RecordDeleted("user", user, reason)
 	return nil
 }
 
-func (host *THost) RemoveGroup(group string) error {
-	connApi, err := host.GetConnectionAPI()
+func (host *THost) RemoveGroup(group string, reason string) error {
+	if host.IsProtected("group", group) {
+		host.Log().Warn("protected group is not removed", "group", group, "reason", reason)
+		return nil
+	}
+	err := host.RunHooks(HookOnDeletion, map[string]string{"kind": "group", "name": group, "reason": reason})
 	if err != nil {
-		return err
+		host.Log().Warn("deletion skipped", "group", group, "error", err)
+		return nil
 	}
-	log.Println(fmt.Sprintf("[%s] delete group \"%s\"", host.IP, group))
-	_, err = connApi.Run("/user/group/remove", "=numbers="+group)
+	host.Log().Info("delete group", "group", group, "reason", reason)
+	_, err = host.RunAPI("/user/group/remove", "=numbers="+group)
 	if err != nil {
 		return err
 	}
+	host.RecordDeleted("group", group, reason)
 	return nil
 }
 
-func (host *THost) RemoveSchedule(schedule string) error {
-	connApi, err := host.GetConnectionAPI()
+func (host *THost) RemoveSchedule(schedule string, reason string) error {
+	if host.IsProtected("schedule", schedule) {
+		host.Log().Warn("protected schedule is not removed", "schedule", schedule, "reason", reason)
+		return nil
+	}
+	err := host.RunHooks(HookOnDeletion, map[string]string{"kind": "schedule", "name": schedule, "reason": reason})
 	if err != nil {
-		return err
+		host.Log().Warn("deletion skipped", "schedule", schedule, "error", err)
+		return nil
 	}
-	log.Println(fmt.Sprintf("[%s] delete schedule \"%s\"", host.IP, schedule))
-	_, err = connApi.Run("/system/scheduler/remove", "=numbers="+schedule)
+	host.Log().Info("delete schedule", "schedule", schedule, "reason", reason)
+	_, err = host.RunAPI("/system/scheduler/remove", "=numbers="+schedule)
 	if err != nil {
 		return err
 	}
+	host.RecordDeleted("schedule", schedule, reason)
 	return nil
 }
 
@@ -572,6 +775,14 @@ func (params *TParams) GetByName(name string) (TParam, error) {
 	return TParam{}, err
 }
 
+func (params *TParams) GetValue(name string) string {
+	param, err := params.GetByName(name)
+	if err != nil {
+		return ""
+	}
+	return param.Value
+}
+
 func (users TUsers) FilterByAliases(aliases TListOfStrings) []*TUser {
 	var slice []*TUser
 	for _, user := range users {
@@ -592,6 +803,16 @@ func (tasks TTasks) GetByName(name string) (*TTask, error) {
 	return &TTask{}, err
 }
 
+func (hosts THosts) GetByNameOrIP(name string) (*THost, error) {
+	for _, host := range hosts {
+		if host.Name == name || host.IP == name {
+			return host, nil
+		}
+	}
+	err := errors.New(fmt.Sprintf("host \"%s\" does not exist", name))
+	return &THost{}, err
+}
+
 func (schedules *TSchedules) FilterByAliases(aliases TListOfStrings) []*TSchedule {
 	var slice []*TSchedule
 	for _, schedule := range *schedules {
@@ -610,7 +831,7 @@ func (schedules *TSchedules) LoadOnEventScripts() error {
 	for _, schedule := range *schedules {
 		byteContent, err := ioutil.ReadFile(dir.Value + schedule.Script)
 		if err != nil {
-			log.Println(fmt.Sprintf("[WARNING] script \"%s\" does not exist", schedule.Script))
+			Log.Warn("script does not exist", "script", schedule.Script)
 			schedule.OnEvent = ""
 		} else {
 			content := string(byteContent)
@@ -624,6 +845,9 @@ func (host *THost) GetUsersAllowed() TListOfStrings {
 	var usersPass TListOfStrings
 	usersPass = append(usersPass, host.Login)
 	usersPass = append(usersPass, host.UsersAllowed...)
+	if Breakglass.Enabled {
+		usersPass = append(usersPass, Breakglass.Login)
+	}
 	for _, user := range host.Users {
 		usersPass = append(usersPass, user.Login)
 	}
@@ -636,6 +860,7 @@ func (host *THost) MakeDir(dir string) error {
 		return err
 	}
 	err = connSftp.MkdirAll(dir)
+	host.RecordAudit("/sftp/mkdir", []string{"=path=" + dir}, err)
 	if err != nil {
 		return err
 	}
@@ -661,11 +886,7 @@ func (host *THost) MakeExport(path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	connApi, err := host.GetConnectionAPI()
-	if err != nil {
-		return "", err
-	}
-	_, err = connApi.Run("/export", "=terse", "=file="+path)
+	_, err = host.RunAPI("/export", "=terse", "=file="+path)
 	if err != nil {
 		return "", err
 	}
@@ -681,11 +902,7 @@ func (host *THost) MakeBackup(path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	connApi, err := host.GetConnectionAPI()
-	if err != nil {
-		return "", err
-	}
-	_, err = connApi.Run("/system/backup/save", "=name="+path)
+	_, err = host.RunAPI("/system/backup/save", "=name="+path)
 	if err != nil {
 		return "", err
 	}
@@ -700,6 +917,7 @@ func (host *THost) RemoveFile(path string) error {
 		return err
 	}
 	err = connSftp.Remove(path)
+	host.RecordAudit("/sftp/remove", []string{"=path=" + path}, err)
 	if err != nil {
 		return err
 	}
@@ -748,19 +966,22 @@ func (host *THost) IsContainSchedule(schedule *TSchedule) bool {
 func (host *THost) GetSshClientConfig() *ssh.ClientConfig {
 	config := &ssh.ClientConfig{
 		User:            host.Login,
-		Auth:            []ssh.AuthMethod{ssh.Password(host.Pass)},
+		Auth:            []ssh.AuthMethod{ssh.Password(host.GetPassword())},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 	return config
 }
 
 func (host *THost) DownloadFolder(dirSrc string, dirDst string, delete bool) error {
-	var err error
-	connSftp, err := host.GetConnectionSFTP()
-	if err != nil {
+	var files []os.FileInfo
+	err := host.RetryStep("read_dir", func() error {
+		connSftp, err := host.GetConnectionSFTP()
+		if err != nil {
+			return err
+		}
+		files, err = connSftp.ReadDir(dirSrc)
 		return err
-	}
-	files, err := connSftp.ReadDir(dirSrc)
+	})
 	if err != nil {
 		return err
 	}
@@ -785,45 +1006,60 @@ func (host *THost) DownloadFile(pathSrc string, dirDst string, delete bool) erro
 	file := filepath.Base(pathSrc)
 	pathSrc = filepath.ToSlash(filepath.Dir(pathSrc)) + "/" + file
 	pathDst := dirDst + "/" + file
-	connSftp, err := host.GetConnectionSFTP()
-	if err != nil {
+	var size int64
+	err = host.RetryStep("download", func() error {
+		size, err = host.CopyFile(pathSrc, pathDst)
 		return err
-	}
-	fileSrc, err := connSftp.Open(pathSrc)
+	})
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(dirDst, 0755)
+	host.RecordDownloaded(pathSrc, pathDst, size)
+	err = host.RunHooks(HookPostBackupDownload, map[string]string{"remote": pathSrc, "local": pathDst, "size": fmt.Sprint(size)})
 	if err != nil {
 		return err
 	}
-	fileDst, err := os.Create(pathDst)
+	if delete {
+		connSftp, err := host.GetConnectionSFTP()
+		if err != nil {
+			return err
+		}
+		err = connSftp.Remove(pathSrc)
+		host.RecordAudit("/sftp/remove", []string{"=path=" + pathSrc}, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (host *THost) CopyFile(pathSrc string, pathDst string) (int64, error) {
+	connSftp, err := host.GetConnectionSFTP()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = io.Copy(fileDst, fileSrc)
+	fileSrc, err := connSftp.Open(pathSrc)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	err = fileDst.Sync()
+	defer func() { _ = fileSrc.Close() }()
+	err = os.MkdirAll(filepath.Dir(pathDst), 0755)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	err = fileSrc.Close()
+	fileDst, err := os.Create(pathDst)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if delete {
-		err = connSftp.Remove(pathSrc)
-		if err != nil {
-			return err
-		}
+	size, err := io.Copy(fileDst, fileSrc)
+	if err == nil {
+		err = fileDst.Sync()
 	}
-	err = fileDst.Close()
+	errClose := fileDst.Close()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return size, errClose
 }
 
 func (users TListOfStrings) IsContain(string string) bool {
@@ -865,11 +1101,13 @@ func (schedules *TSchedules) IsContain(name string) bool {
 func (host *THost) GetConnectionSSH() (*ssh.Client, error) {
 	var err error
 	if host.connections.ssh == nil {
-		log.Println(fmt.Sprintf("[%s] connection via SSH", host.IP))
+		host.Log().Debug("connection via SSH")
 		host.connections.ssh, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", host.IP, host.PortSSH), host.GetSshClientConfig())
 		if err != nil {
+			MetricConnectionErrors.Add(1, host.Name, "ssh")
 			return nil, err
 		}
+		host.SetLastSeen()
 	}
 	return host.connections.ssh, nil
 }
@@ -882,9 +1120,10 @@ func (host *THost) GetConnectionSFTP() (*sftp.Client, error) {
 		if err != nil {
 			return nil, err
 		}
-		log.Println(fmt.Sprintf("[%s] connection via SFTP", host.IP))
+		host.Log().Debug("connection via SFTP")
 		host.connections.sftp, err = sftp.NewClient(connSSH)
 		if err != nil {
+			MetricConnectionErrors.Add(1, host.Name, "sftp")
 			return nil, err
 		}
 	}
@@ -894,63 +1133,32 @@ func (host *THost) GetConnectionSFTP() (*sftp.Client, error) {
 func (host *THost) GetConnectionAPI() (*routeros.Client, error) {
 	var err error
 	if host.connections.api == nil {
-		log.Println(fmt.Sprintf("[%s] connection via API", host.IP))
-		host.connections.api, err = routeros.Dial(fmt.Sprintf("%s:%d", host.IP, host.PortAPI), host.Login, host.Pass)
+		host.Log().Debug("connection via API")
+		host.connections.api, err = routeros.Dial(fmt.Sprintf("%s:%d", host.IP, host.PortAPI), host.Login, host.GetPassword())
 		if err != nil {
+			MetricConnectionErrors.Add(1, host.Name, "api")
 			return nil, err
 		}
+		host.SetLastSeen()
 	}
 	return host.connections.api, nil
 }
 
 func (host *THost) Disconnect() {
 	if host.connections.api != nil {
-		log.Println(fmt.Sprintf("[%s] disconnection via API", host.IP))
+		host.Log().Debug("disconnection via API")
 		host.connections.api.Close()
 		host.connections.api = nil
+		host.connections.routerOS = 0
 	}
 	if host.connections.sftp != nil {
-		log.Println(fmt.Sprintf("[%s] disconnection via SFTP", host.IP))
+		host.Log().Debug("disconnection via SFTP")
 		_ = host.connections.sftp.Close()
 		host.connections.sftp = nil
 	}
 	if host.connections.ssh != nil {
-		log.Println(fmt.Sprintf("[%s] disconnection via SSH", host.IP))
+		host.Log().Debug("disconnection via SSH")
 		_ = host.connections.ssh.Close()
 		host.connections.ssh = nil
 	}
 }
-
-func (user *TUser) GeneratePassword(length int) {
-	var lowerCharSet = "abcdedfghijklmnopqrst"
-	var upperCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	var specialCharSet = "!@#$%&*"
-	var numberSet = "0123456789"
-	var allCharSet = lowerCharSet + upperCharSet + specialCharSet + numberSet
-	var password strings.Builder
-	minSpecialChar := 1
-	minNum := 1
-	minUpperCase := 1
-	for i := 0; i < minSpecialChar; i++ {
-		random := rand.Intn(len(specialCharSet))
-		password.WriteString(string(specialCharSet[random]))
-	}
-	for i := 0; i < minNum; i++ {
-		random := rand.Intn(len(numberSet))
-		password.WriteString(string(numberSet[random]))
-	}
-	for i := 0; i < minUpperCase; i++ {
-		random := rand.Intn(len(upperCharSet))
-		password.WriteString(string(upperCharSet[random]))
-	}
-	remainingLength := length - minSpecialChar - minNum - minUpperCase
-	for i := 0; i < remainingLength; i++ {
-		random := rand.Intn(len(allCharSet))
-		password.WriteString(string(allCharSet[random]))
-	}
-	inRune := []rune(password.String())
-	rand.Shuffle(len(inRune), func(i, j int) {
-		inRune[i], inRune[j] = inRune[j], inRune[i]
-	})
-	user.Pass = string(inRune)
-}
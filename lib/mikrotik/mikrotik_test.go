@@ -0,0 +1,23 @@
+package mikrotik
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// useParams replaces main.json params for the test, paths are relative to a
+// fresh temporary directory.
+func useParams(t *testing.T, values map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := Params
+	Params = TParams{}
+	for name, value := range values {
+		if value != "" {
+			value = filepath.Join(dir, value)
+		}
+		Params = append(Params, &TParam{Name: name, Value: value})
+	}
+	t.Cleanup(func() { Params = saved })
+	return dir
+}
@@ -0,0 +1,204 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+type TNetworks []*TNetwork
+type TNetwork struct {
+	Name     string                    `json:"name"`
+	Prefixes TListOfStrings            `json:"prefixes"`
+	Sites    map[string]TListOfStrings `json:"sites"`
+	Note     string                    `json:"note"`
+}
+
+var Networks TNetworks
+
+var networkName = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// ParsePrefix accepts a CIDR or a single address and returns it in the form
+// RouterOS prints, with host bits cleared.
+func ParsePrefix(value string) (string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, prefix, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("\"%s\" is not an IPv4 or IPv6 prefix", value))
+	}
+	return prefix.String(), nil
+}
+
+func (networks TNetworks) Load() error {
+	names := TListOfStrings{}
+	for _, network := range networks {
+		if !networkName.MatchString(network.Name) || names.IsContain(network.Name) {
+			return errors.New(fmt.Sprintf("network name \"%s\" is invalid or not unique", network.Name))
+		}
+		names = append(names, network.Name)
+		if len(network.Prefixes) == 0 {
+			return errors.New(fmt.Sprintf("network \"%s\": prefixes are empty", network.Name))
+		}
+		lists := []TListOfStrings{network.Prefixes}
+		for _, prefixes := range network.Sites {
+			lists = append(lists, prefixes)
+		}
+		for _, prefixes := range lists {
+			for _, prefix := range prefixes {
+				if _, err := ParsePrefix(prefix); err != nil {
+					return errors.New(fmt.Sprintf("network \"%s\": %s", network.Name, err))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (networks TNetworks) GetByName(name string) *TNetwork {
+	for _, network := range networks {
+		if network.Name == name {
+			return network
+		}
+	}
+	return nil
+}
+
+// GetPrefixes returns the override of the site if there is one.
+func (network *TNetwork) GetPrefixes(site string) TListOfStrings {
+	if prefixes, ok := network.Sites[site]; ok && site != "" {
+		return prefixes
+	}
+	return network.Prefixes
+}
+
+// ExpandAddress resolves a comma separated list of network names and prefixes
+// for a site into the address list of a RouterOS user.
+func ExpandAddress(address string, site string) (string, error) {
+	var expanded TListOfStrings
+	for _, token := range strings.Split(address, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		network := Networks.GetByName(token)
+		if network == nil {
+			parsed, err := ParsePrefix(token)
+			if err != nil {
+				return "", errors.New(fmt.Sprintf("\"%s\" is neither a network from networks.json nor an IPv4 or IPv6 prefix", token))
+			}
+			if !expanded.IsContain(parsed) {
+				expanded = append(expanded, parsed)
+			}
+			continue
+		}
+		prefixes := network.GetPrefixes(site)
+		for _, prefix := range prefixes {
+			parsed, err := ParsePrefix(prefix)
+			if err != nil {
+				return "", err
+			}
+			if !expanded.IsContain(parsed) {
+				expanded = append(expanded, parsed)
+			}
+		}
+	}
+	return strings.Join(expanded, ","), nil
+}
+
+func (host *THost) ExpandAddress(address string) (string, error) {
+	return ExpandAddress(address, host.Site)
+}
+
+// IsAddressEqual compares the address printed by the device with the expanded
+// one as sets of prefixes.
+func IsAddressEqual(actual string, expected string) bool {
+	normalize := func(address string) TListOfStrings {
+		var prefixes TListOfStrings
+		for _, token := range strings.Split(address, ",") {
+			token = strings.TrimSpace(token)
+			if token == "" {
+				continue
+			}
+			if parsed, err := ParsePrefix(token); err == nil {
+				token = parsed
+			}
+			if !prefixes.IsContain(token) {
+				prefixes = append(prefixes, token)
+			}
+		}
+		return prefixes
+	}
+	actualPrefixes, expectedPrefixes := normalize(actual), normalize(expected)
+	if len(actualPrefixes) != len(expectedPrefixes) {
+		return false
+	}
+	for _, prefix := range expectedPrefixes {
+		if !actualPrefixes.IsContain(prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncUserAddress sets the address of a user already on the device when it
+// differs from the configured one. An empty address is not enforced, so
+// restrictions set on the device before address was configured are kept.
+func (host *THost) SyncUserAddress(userInside *TUser, address string) error {
+	if address == "" {
+		return nil
+	}
+	expanded, err := host.ExpandAddress(address)
+	if err != nil {
+		return err
+	}
+	if IsAddressEqual(userInside.Address, expanded) {
+		return nil
+	}
+	host.Log().Info("updating user address", "user", userInside.Login, "address", expanded)
+	_, err = host.RunAPI("/user/set", "=numbers="+userInside.Login, "=address="+expanded)
+	if err != nil {
+		return err
+	}
+	host.RecordUpdated("user", userInside.Login, "address")
+	return nil
+}
+
+// CheckAddresses expands the addresses of users and the break-glass user for
+// every site of the fleet, so an unknown network, a typo or a site override
+// that leaves a user without any prefix fails on load.
+func CheckAddresses() error {
+	sites := TListOfStrings{""}
+	for _, host := range Hosts {
+		if !sites.IsContain(host.Site) {
+			sites = append(sites, host.Site)
+		}
+	}
+	check := func(address string, site string) error {
+		expanded, err := ExpandAddress(address, site)
+		if err == nil && expanded == "" && strings.TrimSpace(address) != "" {
+			err = errors.New(fmt.Sprintf("\"%s\" has no prefixes", address))
+		}
+		if err != nil && site != "" {
+			err = errors.New(fmt.Sprintf("site \"%s\": %s", site, err))
+		}
+		return err
+	}
+	for _, site := range sites {
+		for _, user := range Users {
+			if err := check(user.Address, site); err != nil {
+				return errors.New(fmt.Sprintf("user \"%s\": %s", user.Alias, err))
+			}
+		}
+		if err := check(Breakglass.Address, site); Breakglass.Enabled && err != nil {
+			return errors.New(fmt.Sprintf("breakglass: %s", err))
+		}
+	}
+	return nil
+}
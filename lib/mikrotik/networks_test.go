@@ -0,0 +1,53 @@
+package mikrotik
+
+import "testing"
+
+func useNetworks(t *testing.T, networks TNetworks, hosts THosts, users TUsers) {
+	t.Helper()
+	savedNetworks, savedHosts, savedUsers, savedBreakglass := Networks, Hosts, Users, Breakglass
+	Networks, Hosts, Users, Breakglass = networks, hosts, users, TBreakglass{}
+	t.Cleanup(func() { Networks, Hosts, Users, Breakglass = savedNetworks, savedHosts, savedUsers, savedBreakglass })
+	if err := networks.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAddresses(t *testing.T) {
+	office := &TNetwork{Name: "office", Prefixes: TListOfStrings{"10.0.0.0/8"}, Sites: map[string]TListOfStrings{"branch": {"192.168.0.1"}}}
+	tests := []struct {
+		name  string
+		sites map[string]TListOfStrings
+		fails bool
+	}{
+		{"override of a site", map[string]TListOfStrings{"branch": {"192.168.0.0/24"}}, false},
+		{"empty override of a host site", map[string]TListOfStrings{"branch": {}}, true},
+		{"empty override of an unused site", map[string]TListOfStrings{"lab": {}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			office.Sites = test.sites
+			useNetworks(t, TNetworks{office}, THosts{{IP: "10.0.0.1"}, {IP: "192.168.0.1", Site: "branch"}}, TUsers{{Alias: "alice", Address: "office"}})
+			if err := CheckAddresses(); (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsAddressEqual(t *testing.T) {
+	tests := []struct {
+		actual   string
+		expected string
+		equal    bool
+	}{
+		{"10.0.0.0/8,192.168.0.1/32", "192.168.0.1/32,10.0.0.0/8", true},
+		{"192.168.0.1", "192.168.0.1/32", true},
+		{"", "10.0.0.0/8", false},
+		{"10.0.0.0/8", "10.0.0.0/8,192.168.0.0/24", false},
+	}
+	for _, test := range tests {
+		if IsAddressEqual(test.actual, test.expected) != test.equal {
+			t.Errorf("%q and %q: expected equal %v", test.actual, test.expected, test.equal)
+		}
+	}
+}
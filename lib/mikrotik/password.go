@@ -0,0 +1,117 @@
+package mikrotik
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type TPasswordPolicy struct {
+	Length       int    `json:"length"`
+	Lower        bool   `json:"lower"`
+	Upper        bool   `json:"upper"`
+	Digits       bool   `json:"digits"`
+	Special      bool   `json:"special"`
+	SpecialChars string `json:"special_chars"`
+	MinPerClass  int    `json:"min_per_class"`
+	Exclude      string `json:"exclude"`
+	Note         string `json:"note"`
+	classes      []string
+}
+
+const (
+	passwordLower   = "abcdefghijklmnopqrstuvwxyz"
+	passwordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordDigits  = "0123456789"
+	passwordSpecial = "!#%*+,-.:=@^_~"
+)
+
+// passwordForbidden breaks RouterOS console or script quoting and is never
+// generated, whatever the policy says.
+const passwordForbidden = " \"'`\\$?;{}[]"
+
+var PasswordPolicy = TPasswordPolicy{Length: 32, Lower: true, Upper: true, Digits: true, Special: true, MinPerClass: 1}
+
+func (policy *TPasswordPolicy) Load() error {
+	special := policy.SpecialChars
+	if special == "" {
+		special = passwordSpecial
+	}
+	policy.classes = nil
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{{policy.Lower, passwordLower}, {policy.Upper, passwordUpper}, {policy.Digits, passwordDigits}, {policy.Special, special}} {
+		if !class.enabled {
+			continue
+		}
+		chars := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(policy.Exclude, r) || strings.ContainsRune(passwordForbidden, r) || r > 126 {
+				return -1
+			}
+			return r
+		}, class.chars)
+		if chars == "" {
+			return errors.New("password policy: a character class is empty after exclusions")
+		}
+		policy.classes = append(policy.classes, chars)
+	}
+	if len(policy.classes) == 0 {
+		return errors.New("password policy: no character classes enabled")
+	}
+	if policy.MinPerClass < 0 {
+		return errors.New("password policy: min_per_class must not be negative")
+	}
+	if policy.Length < 8 || policy.Length < policy.MinPerClass*len(policy.classes) {
+		return errors.New(fmt.Sprintf("password policy: length %d is too short", policy.Length))
+	}
+	return nil
+}
+
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(index.Int64()), nil
+}
+
+func (policy *TPasswordPolicy) Generate() (string, error) {
+	var password []byte
+	all := strings.Join(policy.classes, "")
+	for _, chars := range policy.classes {
+		for i := 0; i < policy.MinPerClass; i++ {
+			index, err := randomIndex(len(chars))
+			if err != nil {
+				return "", err
+			}
+			password = append(password, chars[index])
+		}
+	}
+	for len(password) < policy.Length {
+		index, err := randomIndex(len(all))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, all[index])
+	}
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func (user *TUser) GeneratePassword() error {
+	password, err := PasswordPolicy.Generate()
+	if err != nil {
+		return err
+	}
+	user.Pass = password
+	return nil
+}
@@ -0,0 +1,59 @@
+package mikrotik
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TPasswordPolicy
+		fails  bool
+	}{
+		{"default", PasswordPolicy, false},
+		{"digits only", TPasswordPolicy{Length: 12, Digits: true, MinPerClass: 12}, false},
+		{"custom special", TPasswordPolicy{Length: 16, Lower: true, Special: true, SpecialChars: "!@", MinPerClass: 2}, false},
+		{"no classes", TPasswordPolicy{Length: 16}, true},
+		{"too short", TPasswordPolicy{Length: 6, Lower: true}, true},
+		{"shorter than minimum per class", TPasswordPolicy{Length: 8, Lower: true, Upper: true, MinPerClass: 5}, true},
+		{"class excluded", TPasswordPolicy{Length: 8, Digits: true, Exclude: "0123456789"}, true},
+		{"only forbidden special", TPasswordPolicy{Length: 8, Special: true, SpecialChars: "$;'"}, true},
+		{"negative minimum", TPasswordPolicy{Length: 8, Lower: true, MinPerClass: -1}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := test.policy
+			err := policy.Load()
+			if (err != nil) != test.fails {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			for i := 0; i < 50; i++ {
+				password, err := policy.Generate()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(password) != policy.Length {
+					t.Fatalf("expected length %d, got %q", policy.Length, password)
+				}
+				if strings.ContainsAny(password, passwordForbidden+policy.Exclude) {
+					t.Fatalf("password %q contains a forbidden character", password)
+				}
+				for _, chars := range policy.classes {
+					count := 0
+					for _, r := range password {
+						if strings.ContainsRune(chars, r) {
+							count++
+						}
+					}
+					if count < policy.MinPerClass {
+						t.Fatalf("password %q has %d of %q", password, count, chars)
+					}
+				}
+			}
+		})
+	}
+}
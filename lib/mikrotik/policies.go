@@ -0,0 +1,236 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GroupPolicies are the user group policies of each RouterOS major version in
+// the order RouterOS prints them.
+var GroupPolicies = map[int]TListOfStrings{
+	6: {"local", "telnet", "ssh", "ftp", "reboot", "read", "write", "policy", "test", "winbox", "password", "web", "sniff", "sensitive", "api", "romon", "dude", "tikapp"},
+	7: {"local", "telnet", "ssh", "ftp", "reboot", "read", "write", "policy", "test", "winbox", "password", "web", "sniff", "sensitive", "api", "romon", "rest-api"},
+}
+
+var SchedulePolicies = TListOfStrings{"ftp", "reboot", "read", "write", "policy", "test", "password", "sniff", "sensitive", "romon"}
+
+const DefaultRouterOS = 7
+
+// PolicyAll stands for every policy of the RouterOS version of the host.
+const PolicyAll = "all"
+
+func SplitPolicy(policy string) TListOfStrings {
+	var tokens TListOfStrings
+	for _, token := range strings.Split(policy, ",") {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func isKnownGroupPolicy(name string) bool {
+	for _, known := range GroupPolicies {
+		if known.IsContain(name) {
+			return true
+		}
+	}
+	return name == PolicyAll
+}
+
+func CheckPolicyTokens(tokens TListOfStrings, isKnown func(name string) bool) error {
+	for _, token := range tokens {
+		name := strings.TrimPrefix(token, "!")
+		if !isKnown(name) {
+			return errors.New(fmt.Sprintf("unknown policy \"%s\"", name))
+		}
+	}
+	return nil
+}
+
+// Load resolves extends and checks every policy name, the result is a list
+// of tokens applied in order, so a group overrides what it extends.
+func (groups TGroups) Load() error {
+	resolved := map[string]bool{}
+	var resolve func(group *TGroup, chain TListOfStrings) error
+	resolve = func(group *TGroup, chain TListOfStrings) error {
+		if resolved[group.Name] {
+			return nil
+		}
+		if chain.IsContain(group.Name) {
+			return errors.New(fmt.Sprintf("group \"%s\": extends loop %s", group.Name, strings.Join(append(chain, group.Name), " -> ")))
+		}
+		var tokens TListOfStrings
+		if group.Extends != "" {
+			base := groups.GetByName(group.Extends)
+			if base == nil {
+				return errors.New(fmt.Sprintf("group \"%s\": extended group \"%s\" does not exist", group.Name, group.Extends))
+			}
+			err := resolve(base, append(chain, group.Name))
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, base.policy...)
+		}
+		tokens = append(tokens, SplitPolicy(group.Policy)...)
+		tokens = append(tokens, group.Allow...)
+		for _, name := range group.Deny {
+			tokens = append(tokens, "!"+name)
+		}
+		err := CheckPolicyTokens(tokens, isKnownGroupPolicy)
+		if err != nil {
+			return errors.New(fmt.Sprintf("group \"%s\": %s", group.Name, err))
+		}
+		group.policy = tokens
+		resolved[group.Name] = true
+		return nil
+	}
+	for _, group := range groups {
+		err := resolve(group, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (groups TGroups) GetByName(name string) *TGroup {
+	for _, group := range groups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+// RenderPolicy returns the RouterOS policy string for the version and the
+// allowed policies that version does not have.
+func (group *TGroup) RenderPolicy(version int) (string, TListOfStrings) {
+	known, ok := GroupPolicies[version]
+	if !ok {
+		known = GroupPolicies[DefaultRouterOS]
+	}
+	var names TListOfStrings
+	allowed := map[string]bool{}
+	for _, token := range group.policy {
+		name := strings.TrimPrefix(token, "!")
+		if name != PolicyAll {
+			allowed[name] = name == token
+			names = append(names, name)
+			continue
+		}
+		for _, policy := range known {
+			allowed[policy] = name == token
+		}
+	}
+	var unsupported TListOfStrings
+	for _, name := range names {
+		if allowed[name] && !known.IsContain(name) && !unsupported.IsContain(name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	var rendered TListOfStrings
+	for _, policy := range known {
+		if allowed[policy] {
+			rendered = append(rendered, policy)
+		} else {
+			rendered = append(rendered, "!"+policy)
+		}
+	}
+	return strings.Join(rendered, ","), unsupported
+}
+
+// CheckVersion fails on allowed policies the RouterOS version of the host does
+// not have, hosts without routeros are checked as DefaultRouterOS.
+func (group *TGroup) CheckVersion(host *THost) error {
+	version := host.RouterOS
+	if version == 0 {
+		version = DefaultRouterOS
+	}
+	_, unsupported := group.RenderPolicy(version)
+	if len(unsupported) > 0 {
+		return errors.New(fmt.Sprintf("[%s] group \"%s\": policies %s are not supported by RouterOS %d, set routeros in hosts.json when the host runs another version",
+			host.IP, group.Name, strings.Join(unsupported, ","), version))
+	}
+	return nil
+}
+
+// IsPolicyEqual compares the allowed policies of a policy string printed by
+// the device with the rendered one, the order is not significant.
+func IsPolicyEqual(actual string, expected string) bool {
+	allowed := func(policy string) map[string]bool {
+		names := map[string]bool{}
+		for _, token := range SplitPolicy(policy) {
+			if !strings.HasPrefix(token, "!") {
+				names[token] = true
+			}
+		}
+		return names
+	}
+	actualNames, expectedNames := allowed(actual), allowed(expected)
+	if len(actualNames) != len(expectedNames) {
+		return false
+	}
+	for name := range expectedNames {
+		if !actualNames[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadPolicies checks schedule policies, they are a list of allowed policies
+// and the scheduler has nothing to deny.
+func (schedules *TSchedules) LoadPolicies() error {
+	for _, schedule := range *schedules {
+		tokens := SplitPolicy(schedule.Policy)
+		for _, token := range tokens {
+			if strings.HasPrefix(token, "!") {
+				return errors.New(fmt.Sprintf("schedule \"%s\": policy \"%s\" can not be denied, list only the allowed policies", schedule.Name, token))
+			}
+		}
+		err := CheckPolicyTokens(tokens, func(name string) bool { return SchedulePolicies.IsContain(name) })
+		if err != nil {
+			return errors.New(fmt.Sprintf("schedule \"%s\": %s", schedule.Name, err))
+		}
+	}
+	return nil
+}
+
+// RenderPolicy returns the allowed scheduler policies in RouterOS order.
+func (schedule *TSchedule) RenderPolicy() string {
+	tokens := SplitPolicy(schedule.Policy)
+	var rendered TListOfStrings
+	for _, policy := range SchedulePolicies {
+		if tokens.IsContain(policy) {
+			rendered = append(rendered, policy)
+		}
+	}
+	return strings.Join(rendered, ",")
+}
+
+// GetRouterOS returns the major version from hosts.json or from the device,
+// detected once per connection.
+func (host *THost) GetRouterOS() int {
+	if host.RouterOS != 0 {
+		return host.RouterOS
+	}
+	if host.connections.routerOS != 0 {
+		return host.connections.routerOS
+	}
+	res, err := host.RunAPI("/system/resource/print")
+	if err == nil && len(res.Re) > 0 {
+		version := res.Re[0].Map["version"]
+		major, errParse := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+		if errParse == nil {
+			host.connections.routerOS = major
+			return major
+		}
+		err = errors.New(fmt.Sprintf("unknown version \"%s\"", version))
+	}
+	host.Log().Warn("RouterOS version is not detected, using default", "default", DefaultRouterOS, "error", err)
+	return DefaultRouterOS
+}
@@ -0,0 +1,140 @@
+package mikrotik
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupPolicyLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups TGroups
+		fails  bool
+	}{
+		{"plain", TGroups{{Name: "read", Policy: "read,winbox,!write"}}, false},
+		{"structured", TGroups{{Name: "ops", Allow: TListOfStrings{"read", "write"}, Deny: TListOfStrings{"policy"}}}, false},
+		{"extends", TGroups{{Name: "base", Allow: TListOfStrings{"read"}}, {Name: "ops", Extends: "base", Allow: TListOfStrings{"write"}}}, false},
+		{"unknown policy", TGroups{{Name: "bad", Allow: TListOfStrings{"reed"}}}, true},
+		{"unknown base", TGroups{{Name: "ops", Extends: "base"}}, true},
+		{"loop", TGroups{{Name: "a", Extends: "b"}, {Name: "b", Extends: "a"}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.groups.Load()
+			if (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestGroupPolicyRender(t *testing.T) {
+	base := &TGroup{Name: "base", Allow: TListOfStrings{"read", "winbox", "rest-api"}}
+	tests := []struct {
+		name        string
+		group       *TGroup
+		version     int
+		allowed     TListOfStrings
+		unsupported TListOfStrings
+	}{
+		{"allowed policies", base, 7, TListOfStrings{"read", "winbox", "rest-api"}, nil},
+		{"missing in version 6", base, 6, TListOfStrings{"read", "winbox"}, TListOfStrings{"rest-api"}},
+		{"deny overrides extends", &TGroup{Name: "ops", Extends: "base", Allow: TListOfStrings{"write"}, Deny: TListOfStrings{"winbox"}}, 7, TListOfStrings{"read", "write", "rest-api"}, nil},
+		{"all but", &TGroup{Name: "full", Policy: "all", Deny: TListOfStrings{"policy", "sensitive"}}, 6, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := (TGroups{base, test.group}).Load(); err != nil {
+				t.Fatal(err)
+			}
+			rendered, unsupported := test.group.RenderPolicy(test.version)
+			known := GroupPolicies[test.version]
+			if strings.Count(rendered, ",")+1 != len(known) {
+				t.Errorf("policy %q does not list every policy of version %d", rendered, test.version)
+			}
+			allowed := test.allowed
+			if test.group.Name == "full" {
+				allowed = nil
+				for _, policy := range known {
+					if policy != "policy" && policy != "sensitive" {
+						allowed = append(allowed, policy)
+					}
+				}
+			}
+			for _, token := range strings.Split(rendered, ",") {
+				if allowed.IsContain(token) == strings.HasPrefix(token, "!") {
+					t.Errorf("policy %q: unexpected token %q", rendered, token)
+				}
+			}
+			if strings.Join(unsupported, ",") != strings.Join(test.unsupported, ",") {
+				t.Errorf("expected unsupported %v, got %v", test.unsupported, unsupported)
+			}
+		})
+	}
+}
+
+func TestSchedulePolicy(t *testing.T) {
+	schedules := TSchedules{{Name: "backup", Policy: "write,read,ftp"}}
+	if err := schedules.LoadPolicies(); err != nil {
+		t.Fatal(err)
+	}
+	if rendered := schedules[0].RenderPolicy(); rendered != "ftp,read,write" {
+		t.Errorf("unexpected policy %q", rendered)
+	}
+	schedules = TSchedules{{Name: "bad", Policy: "read,winbox"}}
+	if err := schedules.LoadPolicies(); err == nil {
+		t.Error("group policy is accepted for a schedule")
+	}
+	schedules = TSchedules{{Name: "deny", Policy: "read,!write"}}
+	if err := schedules.LoadPolicies(); err == nil {
+		t.Error("denied policy is accepted for a schedule")
+	}
+}
+
+func TestGroupPolicyVersion(t *testing.T) {
+	group := &TGroup{Name: "monitoring", Allow: TListOfStrings{"read", "dude"}}
+	if err := (TGroups{group}).Load(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		host  *THost
+		fails bool
+	}{
+		{"version of the host", &THost{IP: "10.0.0.1", RouterOS: 6}, false},
+		{"policy missing in the version", &THost{IP: "10.0.0.2", RouterOS: 7}, true},
+		{"default version", &THost{IP: "10.0.0.3"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := group.CheckVersion(test.host); (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsPolicyEqual(t *testing.T) {
+	group := &TGroup{Name: "read", Policy: "read,winbox,api"}
+	if err := (TGroups{group}).Load(); err != nil {
+		t.Fatal(err)
+	}
+	rendered, _ := group.RenderPolicy(7)
+	if !IsPolicyEqual("api,read,winbox,!write", rendered) {
+		t.Error("same allowed policies in another order differ")
+	}
+	if IsPolicyEqual("read,winbox,api,write", rendered) || IsPolicyEqual("read,winbox", rendered) {
+		t.Error("changed policy is equal")
+	}
+}
+
+func TestImportPolicy(t *testing.T) {
+	printed := "local,telnet,ssh,!ftp,reboot,read,write,!policy,test,winbox,password,web,!sniff,sensitive,api,romon,!rest-api"
+	group := &TGroup{Name: "imported", Allow: ImportPolicy(printed)}
+	if err := (TGroups{group}).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if rendered, _ := group.RenderPolicy(7); rendered != printed {
+		t.Errorf("imported policy renders as %q", rendered)
+	}
+}
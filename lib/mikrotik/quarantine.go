@@ -0,0 +1,100 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	UnknownUsersDelete  = "delete"
+	UnknownUsersDisable = "disable"
+	UnknownUsersReport  = "report"
+)
+
+const QuarantineMarker = "rosman-quarantine"
+
+const defaultQuarantineDays = 14
+
+var unknownUsersPolicies = TListOfStrings{UnknownUsersDelete, UnknownUsersDisable, UnknownUsersReport}
+
+func CheckUnknownUsersPolicy(policy string) error {
+	if policy != "" && !unknownUsersPolicies.IsContain(policy) {
+		return errors.New(fmt.Sprintf("unknown_users policy \"%s\" does not exist", policy))
+	}
+	return nil
+}
+
+func (host *THost) GetUnknownUsersPolicy() string {
+	if host.UnknownUsers != "" {
+		return host.UnknownUsers
+	}
+	if Safeguards.UnknownUsers != "" {
+		return Safeguards.UnknownUsers
+	}
+	return UnknownUsersDelete
+}
+
+func (host *THost) GetQuarantinePeriod() time.Duration {
+	days := Safeguards.QuarantineDays
+	if host.QuarantineDays != nil {
+		days = *host.QuarantineDays
+	}
+	if days <= 0 {
+		days = defaultQuarantineDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// FormatQuarantineComment keeps the original comment after the marker so that
+// it can be restored when the user is added to config during the grace period.
+func FormatQuarantineComment(since time.Time, comment string) string {
+	marked := QuarantineMarker + " " + since.UTC().Format(time.RFC3339)
+	if comment != "" {
+		marked += "; " + comment
+	}
+	return marked
+}
+
+func ParseQuarantineComment(comment string) (time.Time, string, bool) {
+	if !strings.HasPrefix(comment, QuarantineMarker+" ") {
+		return time.Time{}, comment, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(comment, QuarantineMarker+" "), "; ", 2)
+	since, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return time.Time{}, comment, false
+	}
+	var original string
+	if len(parts) > 1 {
+		original = parts[1]
+	}
+	return since, original, true
+}
+
+func (host *THost) DisableUser(user *TUser) error {
+	host.Log().Info("quarantine user", "user", user.Login, "grace", host.GetQuarantinePeriod())
+	_, err := host.RunAPI("/user/set", "=numbers="+user.Login, "=disabled=yes", "=comment="+FormatQuarantineComment(time.Now(), user.Comment))
+	if err != nil {
+		return err
+	}
+	host.RecordDisabled("user", user.Login, "not in config")
+	return nil
+}
+
+func (host *THost) ReleaseUser(user *TUser) error {
+	_, original, _ := ParseQuarantineComment(user.Comment)
+	host.Log().Info("release user from quarantine", "user", user.Login)
+	_, err := host.RunAPI("/user/set", "=numbers="+user.Login, "=disabled=no", "=comment="+original)
+	if err != nil {
+		return err
+	}
+	host.RecordEnabled("user", user.Login, "added to config")
+	return nil
+}
+
+func (host *THost) ReportUnknownUser(user *TUser) {
+	message := fmt.Sprintf("unknown user \"%s\" (group %s) is not in config", user.Login, user.Group)
+	host.EmitRun(EventUnknownUser, message, map[string]string{"kind": "user", "name": user.Login, "group": user.Group, "comment": user.Comment})
+}
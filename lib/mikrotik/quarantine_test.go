@@ -0,0 +1,78 @@
+package mikrotik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuarantineComment(t *testing.T) {
+	since := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name     string
+		comment  string
+		original string
+	}{
+		{"with comment", "Bob; NOC", "Bob; NOC"},
+		{"without comment", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			marked := FormatQuarantineComment(since, test.comment)
+			parsed, original, ok := ParseQuarantineComment(marked)
+			if !ok {
+				t.Fatalf("marker is not found in %q", marked)
+			}
+			if !parsed.Equal(since) || original != test.original {
+				t.Errorf("expected %v %q, got %v %q", since, test.original, parsed, original)
+			}
+		})
+	}
+	for _, comment := range []string{"Bob", QuarantineMarker + " yesterday", QuarantineMarker} {
+		if _, original, ok := ParseQuarantineComment(comment); ok || original != comment {
+			t.Errorf("comment %q is parsed as quarantined", comment)
+		}
+	}
+}
+
+func TestUnknownUsersPolicy(t *testing.T) {
+	saved := Safeguards
+	t.Cleanup(func() { Safeguards = saved })
+	Safeguards = TSafeguards{}
+	host := &THost{}
+	if policy := host.GetUnknownUsersPolicy(); policy != UnknownUsersDelete {
+		t.Errorf("expected default policy delete, got %s", policy)
+	}
+	Safeguards.UnknownUsers = UnknownUsersDisable
+	if policy := host.GetUnknownUsersPolicy(); policy != UnknownUsersDisable {
+		t.Errorf("expected global policy disable, got %s", policy)
+	}
+	host.UnknownUsers = UnknownUsersReport
+	if policy := host.GetUnknownUsersPolicy(); policy != UnknownUsersReport {
+		t.Errorf("expected host policy report, got %s", policy)
+	}
+}
+
+func TestQuarantinePeriod(t *testing.T) {
+	saved := Safeguards
+	t.Cleanup(func() { Safeguards = saved })
+	days := 3
+	zero := 0
+	tests := []struct {
+		name   string
+		global int
+		host   *int
+		period time.Duration
+	}{
+		{"default", 0, nil, defaultQuarantineDays * 24 * time.Hour},
+		{"global", 7, nil, 7 * 24 * time.Hour},
+		{"host overrides", 7, &days, 3 * 24 * time.Hour},
+		{"zero is the default", 7, &zero, defaultQuarantineDays * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		Safeguards = TSafeguards{QuarantineDays: test.global}
+		host := &THost{QuarantineDays: test.host}
+		if period := host.GetQuarantinePeriod(); period != test.period {
+			t.Errorf("%s: expected %v, got %v", test.name, test.period, period)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type TRetries map[string]*TRetry
+type TRetry struct {
+	MaxAttempts int     `json:"max_attempts"`
+	Delay       float64 `json:"delay"`
+	MaxDelay    float64 `json:"max_delay"`
+	Multiplier  float64 `json:"multiplier"`
+	Jitter      float64 `json:"jitter"`
+}
+
+type TErrorClass int
+
+const (
+	ErrorUnknown TErrorClass = iota
+	ErrorTransient
+	ErrorPermanent
+)
+
+type TPermanentError struct {
+	Err error
+}
+
+var DefaultRetries = TRetries{
+	"import_ssh_key": {MaxAttempts: 10, Delay: 5, MaxDelay: 60, Multiplier: 1.5, Jitter: 0.2},
+}
+
+var permanentErrorPatterns = TListOfStrings{
+	"invalid user name or password",
+	"cannot log in",
+	"unable to authenticate",
+	"permission denied",
+	"not enough permissions",
+}
+
+var transientErrorPatterns = TListOfStrings{
+	"timeout",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"no route to host",
+	"network is unreachable",
+	"use of closed network connection",
+}
+
+var retryRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
+var retryRandomMutex sync.Mutex
+
+func (err *TPermanentError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *TPermanentError) Unwrap() error {
+	return err.Err
+}
+
+func ClassifyError(err error) TErrorClass {
+	if err == nil {
+		return ErrorUnknown
+	}
+	var permanentErr *TPermanentError
+	if errors.As(err, &permanentErr) {
+		return ErrorPermanent
+	}
+	message := strings.ToLower(err.Error())
+	for _, pattern := range permanentErrorPatterns {
+		if strings.Contains(message, pattern) {
+			return ErrorPermanent
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorTransient
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return ErrorTransient
+	}
+	for _, pattern := range transientErrorPatterns {
+		if strings.Contains(message, pattern) {
+			return ErrorTransient
+		}
+	}
+	return ErrorUnknown
+}
+
+func (class TErrorClass) String() string {
+	switch class {
+	case ErrorTransient:
+		return "transient"
+	case ErrorPermanent:
+		return "permanent"
+	}
+	return "unknown"
+}
+
+// DefaultStepAttempts limits a step retry without max_attempts, the host is
+// locked while it waits.
+const DefaultStepAttempts = 3
+
+func (retry *TRetry) IsExhausted(attempt int) bool {
+	return retry.MaxAttempts > 0 && attempt >= retry.MaxAttempts
+}
+
+func (retry *TRetry) GetDelay(attempt int) time.Duration {
+	multiplier := retry.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := retry.Delay * math.Pow(multiplier, float64(attempt-1))
+	if retry.MaxDelay > 0 && delay > retry.MaxDelay {
+		delay = retry.MaxDelay
+	}
+	if retry.Jitter > 0 {
+		retryRandomMutex.Lock()
+		delay = delay * (1 - retry.Jitter + 2*retry.Jitter*retryRandom.Float64())
+		retryRandomMutex.Unlock()
+	}
+	return time.Duration(delay * float64(time.Second))
+}
+
+func (task *TTask) GetRetry() *TRetry {
+	if task.Retry != nil {
+		return task.Retry
+	}
+	return &TRetry{Delay: float64(task.Expired), Multiplier: 1}
+}
+
+func (host *THost) GetRetry(name string) *TRetry {
+	if host.job != nil {
+		if retry, ok := host.job.StepRetry[name]; ok {
+			return retry
+		}
+	}
+	if retry, ok := DefaultRetries[name]; ok {
+		return retry
+	}
+	return nil
+}
+
+func (host *THost) Retry(retry *TRetry, name string, fn func() error) error {
+	if retry == nil {
+		return fn()
+	}
+	if retry.MaxAttempts <= 0 {
+		limited := *retry
+		limited.MaxAttempts = DefaultStepAttempts
+		retry = &limited
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		class := ClassifyError(err)
+		if class == ErrorPermanent {
+			return err
+		}
+		if retry.IsExhausted(attempt) {
+			return fmt.Errorf("%s: all %d attempts used: %w", name, attempt, err)
+		}
+		if class == ErrorTransient {
+			host.Disconnect()
+		}
+		delay := retry.GetDelay(attempt)
+		host.Log().Warn("attempt failed", "operation", name, "attempt", attempt, "class", class, "error", err, "delay", delay)
+		time.Sleep(delay)
+	}
+}
+
+// RetryStep retries fn with the step_retry policy of the running step. Only
+// operations that leave the device unchanged are retried: connecting and
+// reading.
+func (host *THost) RetryStep(name string, fn func() error) error {
+	return host.Retry(host.stepRetry, name, fn)
+}
@@ -0,0 +1,111 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class TErrorClass
+	}{
+		{nil, ErrorUnknown},
+		{errors.New("from RouterOS device: invalid user name or password (6)"), ErrorPermanent},
+		{errors.New("not enough permissions (9)"), ErrorPermanent},
+		{&TPermanentError{Err: errors.New("bad config")}, ErrorPermanent},
+		{fmt.Errorf("sync: %w", &TPermanentError{Err: errors.New("bad config")}), ErrorPermanent},
+		{io.EOF, ErrorTransient},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), ErrorTransient},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorTransient},
+		{errors.New("i/o timeout"), ErrorTransient},
+		{errors.New("failure: already have user with this name"), ErrorUnknown},
+	}
+	for _, test := range tests {
+		if class := ClassifyError(test.err); class != test.class {
+			t.Errorf("%v: expected %s, got %s", test.err, test.class, class)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	retry := &TRetry{MaxAttempts: 4, Delay: 1, MaxDelay: 5, Multiplier: 3}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 3 * time.Second, 3: 5 * time.Second, 4: 5 * time.Second} {
+		if delay := retry.GetDelay(attempt); delay != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected, delay)
+		}
+	}
+	retry.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := retry.GetDelay(1); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("delay %s is outside of the jitter", delay)
+		}
+	}
+	if retry.IsExhausted(3) || !retry.IsExhausted(4) {
+		t.Error("attempts are not limited by max_attempts")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		fails    bool
+	}{
+		{"success", nil, 1, false},
+		{"transient then success", []error{transient, transient}, 3, false},
+		{"permanent is not retried", []error{&TPermanentError{Err: errors.New("denied")}}, 1, true},
+		{"attempts are limited", []error{transient, transient, transient, transient}, 3, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			host := &THost{Name: "test", IP: "127.0.0.1"}
+			attempts := 0
+			err := host.Retry(&TRetry{MaxAttempts: 3}, "test", func() error {
+				attempts++
+				if attempts <= len(test.errs) {
+					return test.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+			if attempts != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryLimits(t *testing.T) {
+	host := &THost{Name: "test", IP: "127.0.0.1"}
+	attempts := 0
+	cause := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	err := host.Retry(&TRetry{}, "test", func() error {
+		attempts++
+		return cause
+	})
+	if attempts != DefaultStepAttempts {
+		t.Errorf("expected %d attempts without max_attempts, got %d", DefaultStepAttempts, attempts)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("cause is not wrapped: %v", err)
+	}
+	attempts = 0
+	_ = host.RetryStep("test", func() error {
+		attempts++
+		return cause
+	})
+	if attempts != 1 {
+		t.Errorf("operation outside of a step is retried %d times", attempts)
+	}
+}
@@ -0,0 +1,228 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TPasswordState struct {
+	Version int    `json:"version"`
+	Rotated int64  `json:"rotated"`
+	Error   string `json:"error,omitempty"`
+}
+
+type TRotationStatus struct {
+	Login   string
+	Host    *THost
+	Latest  int
+	Version int
+	Rotated int64
+	Error   string
+}
+
+var rotationMutex sync.Mutex
+
+// FleetSecretName is shared by all hosts carrying the user, so every host
+// converges on the latest version of it.
+func FleetSecretName(login string) string {
+	return "user/" + login
+}
+
+// IsRotated tells whether the user has a fleet password, by rotate_days or
+// by rosman rotate. The store is read once per run, not once per user.
+func (host *THost) IsRotated(user *TUser) bool {
+	if user.RotateDays > 0 {
+		return true
+	}
+	if host.rotated == nil {
+		host.rotated = map[string]bool{}
+		secrets, _, err := ListSecrets()
+		if err != nil && !errors.Is(err, ErrSecretsDisabled) {
+			host.Log().Warn("secrets are not read, rotation only by rotate_days", "error", err)
+		}
+		for name, versions := range secrets {
+			host.rotated[name] = len(versions) > 0
+		}
+	}
+	return host.rotated[FleetSecretName(user.Login)]
+}
+
+// NewUserPassword generates and stores the next version of the fleet password,
+// hosts pick it up on their next rotate_passwords step.
+func NewUserPassword(user *TUser) (int, error) {
+	rotationMutex.Lock()
+	defer rotationMutex.Unlock()
+	return newUserPassword(user)
+}
+
+func newUserPassword(user *TUser) (int, error) {
+	generated := TUser{Login: user.Login}
+	err := generated.GeneratePassword()
+	if err != nil {
+		return 0, err
+	}
+	version, err := PutSecret(FleetSecretName(user.Login), generated.Pass)
+	if err != nil {
+		return 0, err
+	}
+	Log.Info("new password version generated", "user", user.Login, "version", version)
+	return version, nil
+}
+
+// GetRotationTarget returns the password every host should carry. The first
+// host that finds the latest version older than rotate_days generates the
+// next one. Version 0 means the user has no fleet password.
+func GetRotationTarget(user *TUser) (string, int, error) {
+	rotationMutex.Lock()
+	defer rotationMutex.Unlock()
+	secrets, _, err := ListSecrets()
+	if err != nil {
+		return "", 0, err
+	}
+	versions := secrets[FleetSecretName(user.Login)]
+	version := 0
+	if len(versions) > 0 {
+		version = versions[len(versions)-1].Version
+	}
+	due := len(versions) == 0 || time.Since(versions[len(versions)-1].Created) >= time.Duration(user.RotateDays)*24*time.Hour
+	if user.RotateDays > 0 && due {
+		version, err = newUserPassword(user)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	if version == 0 {
+		return "", 0, nil
+	}
+	password, _, err := GetSecret(FleetSecretName(user.Login), version)
+	return password, version, err
+}
+
+func (host *THost) GetPasswordState(login string) *TPasswordState {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	if state, ok := host.passwords[login]; ok {
+		copied := *state
+		return &copied
+	}
+	return &TPasswordState{}
+}
+
+func (host *THost) SetPasswordState(login string, version int, err error) {
+	stateMutex.Lock()
+	if host.passwords == nil {
+		host.passwords = map[string]*TPasswordState{}
+	}
+	state, ok := host.passwords[login]
+	if !ok {
+		state = &TPasswordState{}
+		host.passwords[login] = state
+	}
+	MarkState(statePasswordKey(host, login))
+	if err != nil {
+		state.Error = err.Error()
+	} else {
+		state.Version = version
+		state.Rotated = time.Now().Unix()
+		state.Error = ""
+	}
+	stateMutex.Unlock()
+	errSave := SaveState()
+	if errSave != nil {
+		host.Log().Error("state error", "error", errSave)
+	}
+}
+
+// RotatePasswords sets the latest fleet password for every rotated user the
+// host carries. A failed user does not stop the others, the step fails at the
+// end so that the host is retried and reported.
+func (host *THost) RotatePasswords() error {
+	var failed TListOfStrings
+	var users []*TUser
+	for _, user := range host.Users {
+		if host.IsRotated(user) {
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	usersInside, err := host.GetUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if !TUsers(usersInside).IsContain(user.Login) {
+			host.Log().Debug("user is not on host, rotation skipped", "user", user.Login)
+			continue
+		}
+		password, version, err := GetRotationTarget(user)
+		if errors.Is(err, ErrSecretsDisabled) {
+			return &TPermanentError{Err: err}
+		}
+		if err != nil {
+			return err
+		}
+		if version == 0 || host.GetPasswordState(user.Login).Version == version {
+			continue
+		}
+		host.Log().Info("rotating password", "user", user.Login, "version", version)
+		_, err = host.RunAPI("/user/set", "=numbers="+user.Login, "=password="+password)
+		host.SetPasswordState(user.Login, version, err)
+		if err != nil {
+			host.Log().Error("password rotation failed", "user", user.Login, "version", version, "error", err)
+			host.EmitRun(EventRotationFailed, fmt.Sprintf("password rotation of user \"%s\" to version %d failed: %s", user.Login, version, err),
+				map[string]string{"user": user.Login, "version": fmt.Sprint(version), "error": err.Error()})
+			failed = append(failed, user.Login)
+			continue
+		}
+		host.RecordRotated("user", user.Login, fmt.Sprintf("version %d", version))
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("password rotation failed for %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// GetRotationStatus lists every host carrying a rotated user with the version
+// it has applied, hosts behind the latest version have mixed passwords.
+func GetRotationStatus() ([]*TRotationStatus, error) {
+	var statuses []*TRotationStatus
+	secrets, _, err := ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range Users {
+		versions := secrets[FleetSecretName(user.Login)]
+		if user.RotateDays == 0 && len(versions) == 0 {
+			continue
+		}
+		latest := 0
+		if len(versions) > 0 {
+			latest = versions[len(versions)-1].Version
+		}
+		for _, host := range Hosts {
+			users, _, _ := host.GetManaged()
+			if !users.IsContain(user.Login) {
+				continue
+			}
+			state := host.GetPasswordState(user.Login)
+			statuses = append(statuses, &TRotationStatus{
+				Login:   user.Login,
+				Host:    host,
+				Latest:  latest,
+				Version: state.Version,
+				Rotated: state.Rotated,
+				Error:   state.Error,
+			})
+		}
+	}
+	return statuses, nil
+}
+
+func (status *TRotationStatus) IsCurrent() bool {
+	return status.Latest > 0 && status.Version == status.Latest
+}
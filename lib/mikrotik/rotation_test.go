@@ -0,0 +1,82 @@
+package mikrotik
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsRotated(t *testing.T) {
+	useSecrets(t)
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	scheduled := &TUser{Login: "scheduled", RotateDays: 30}
+	manual := &TUser{Login: "manual"}
+	plain := &TUser{Login: "plain"}
+	if _, err := PutSecret(FleetSecretName(manual.Login), "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !host.IsRotated(scheduled) || !host.IsRotated(manual) || host.IsRotated(plain) {
+		t.Error("rotated users are not told from the others")
+	}
+	if _, err := PutSecret(FleetSecretName(plain.Login), "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if host.IsRotated(plain) {
+		t.Error("store is read again in the same run")
+	}
+	host.rotated = nil
+	if !host.IsRotated(plain) {
+		t.Error("store is not read in the next run")
+	}
+}
+
+func TestCheckSecretsStoreRotation(t *testing.T) {
+	users := Users
+	t.Cleanup(func() { Users = users })
+	t.Setenv(secretsKeyEnv, "")
+	useParams(t, map[string]string{"secrets_key_file": "missing", "file_secrets": "secrets.json"})
+	Users = TUsers{{Alias: "teamlead", Login: "teamlead", Pass: "password", RotateDays: 90}}
+	if err := CheckSecretsStore(); err == nil {
+		t.Error("rotate_days is accepted without a store")
+	}
+}
+
+func TestLockHost(t *testing.T) {
+	useParams(t, map[string]string{"dir_locks": "locks"})
+	host := &THost{IP: "10.0.0.1"}
+	lock, err := host.LockHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another process, a second open of the lock file stands in for it
+	acquired := make(chan *TFileLock)
+	go func() {
+		other, err := (&THost{IP: "10.0.0.1"}).LockHost()
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- other
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("host lock is taken twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+	lock.Unlock()
+	select {
+	case other := <-acquired:
+		other.Unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("host lock is not released")
+	}
+	// without dir_locks and a secrets store the host is still locked
+	useParams(t, map[string]string{"dir_locks": "", "file_secrets": ""})
+	t.Setenv("TMPDIR", t.TempDir())
+	lock, err = host.LockHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.file == nil {
+		t.Error("no lock is taken without dir_locks")
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,125 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+type TSafeguards struct {
+	ProtectedUsers     TListOfStrings `json:"protected_users"`
+	ProtectedGroups    TListOfStrings `json:"protected_groups"`
+	ProtectedSchedules TListOfStrings `json:"protected_schedules"`
+	MaxDeletions       int            `json:"max_deletions"`
+	UnknownUsers       string         `json:"unknown_users"`
+	QuarantineDays     int            `json:"quarantine_days"`
+	Note               string         `json:"note"`
+}
+
+type TRunOptions struct {
+	ForceDeletions bool `json:"force_deletions"`
+}
+
+// BuiltinGroups are shipped with RouterOS and can not be recreated from
+// groups.json once removed, so they are protected regardless of configuration.
+var BuiltinGroups = TListOfStrings{"full", "read", "write"}
+
+var Safeguards TSafeguards
+
+func (safeguards *TSafeguards) Load() error {
+	patterns := append(append(append(TListOfStrings{}, safeguards.ProtectedUsers...), safeguards.ProtectedGroups...), safeguards.ProtectedSchedules...)
+	for _, host := range Hosts {
+		patterns = append(append(append(patterns, host.ProtectedUsers...), host.ProtectedGroups...), host.ProtectedSchedules...)
+		if host.MaxDeletions != nil && *host.MaxDeletions < 0 {
+			return errors.New(fmt.Sprintf("[%s] max_deletions must not be negative", host.IP))
+		}
+		if err := CheckUnknownUsersPolicy(host.UnknownUsers); err != nil {
+			return errors.New(fmt.Sprintf("[%s] %s", host.IP, err))
+		}
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New(fmt.Sprintf("invalid protected pattern \"%s\": %s", pattern, err))
+		}
+	}
+	if safeguards.MaxDeletions < 0 {
+		return errors.New("max_deletions must not be negative")
+	}
+	return CheckUnknownUsersPolicy(safeguards.UnknownUsers)
+}
+
+func matchAny(patterns TListOfStrings, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (host *THost) IsProtected(kind string, name string) bool {
+	switch kind {
+	case "user":
+		return strings.EqualFold(name, host.Login) || Breakglass.IsLogin(name) || matchAny(Safeguards.ProtectedUsers, name) || matchAny(host.ProtectedUsers, name)
+	case "group":
+		return BuiltinGroups.IsContain(name) || matchAny(Safeguards.ProtectedGroups, name) || matchAny(host.ProtectedGroups, name)
+	case "schedule":
+		return matchAny(Safeguards.ProtectedSchedules, name) || matchAny(host.ProtectedSchedules, name)
+	case "ssh_key":
+		return host.IsProtected("user", strings.SplitN(name, " ", 2)[0])
+	}
+	return false
+}
+
+// GetMaxDeletions returns the limit of deletions per run, 0 means unlimited.
+func (host *THost) GetMaxDeletions() int {
+	if host.MaxDeletions != nil {
+		return *host.MaxDeletions
+	}
+	return Safeguards.MaxDeletions
+}
+
+func (host *THost) CountDeleted() int {
+	var count int
+	if host.run == nil {
+		return count
+	}
+	for _, step := range host.run.Steps {
+		count += len(step.Deleted) + len(step.Disabled)
+	}
+	return count
+}
+
+// CheckDeletions is called with every object a step is about to remove before
+// the first of them is removed, so an exceeded limit leaves the device as is.
+func (host *THost) CheckDeletions(kind string, names TListOfStrings) error {
+	limit := host.GetMaxDeletions()
+	if limit == 0 || len(names) == 0 {
+		return nil
+	}
+	total := host.CountDeleted() + len(names)
+	if total <= limit {
+		return nil
+	}
+	if host.options.ForceDeletions {
+		host.Log().Warn("deletion limit exceeded, forced", "kind", kind, "count", len(names), "limit", limit)
+		return nil
+	}
+	return &TPermanentError{Err: errors.New(fmt.Sprintf("refusing to remove %d %ss (%s): %d removals exceed the limit of %d per run, run with force_deletions to override",
+		len(names), kind, strings.Join(names, ", "), total, limit))}
+}
+
+// FilterDeletions drops protected objects from the deletion candidates and
+// checks the remaining ones against the deletion limit.
+func (host *THost) FilterDeletions(kind string, names TListOfStrings) (TListOfStrings, error) {
+	var allowed TListOfStrings
+	for _, name := range names {
+		if host.IsProtected(kind, name) {
+			host.Log().Info("protected object is kept", "kind", kind, "name", name)
+			continue
+		}
+		allowed = append(allowed, name)
+	}
+	return allowed, host.CheckDeletions(kind, allowed)
+}
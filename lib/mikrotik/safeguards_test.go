@@ -0,0 +1,106 @@
+package mikrotik
+
+import "testing"
+
+func TestIsProtected(t *testing.T) {
+	savedSafeguards, savedBreakglass := Safeguards, Breakglass
+	t.Cleanup(func() { Safeguards, Breakglass = savedSafeguards, savedBreakglass })
+	Safeguards = TSafeguards{ProtectedUsers: TListOfStrings{"svc-*"}, ProtectedSchedules: TListOfStrings{"backup"}}
+	Breakglass = TBreakglass{Enabled: true, Login: "emergency"}
+	host := &THost{Login: "rosman", ProtectedUsers: TListOfStrings{"noc"}, ProtectedGroups: TListOfStrings{"ops"}}
+	tests := []struct {
+		kind      string
+		name      string
+		protected bool
+	}{
+		{"user", "rosman", true},
+		{"user", "ROSMAN", true},
+		{"user", "emergency", true},
+		{"user", "svc-backup", true},
+		{"user", "noc", true},
+		{"user", "bob", false},
+		{"group", "full", true},
+		{"group", "ops", true},
+		{"group", "staff", false},
+		{"schedule", "backup", true},
+		{"schedule", "cleanup", false},
+		{"ssh_key", "noc ed25519 key", true},
+		{"ssh_key", "bob ed25519 key", false},
+		{"script", "rosman", false},
+	}
+	for _, test := range tests {
+		if protected := host.IsProtected(test.kind, test.name); protected != test.protected {
+			t.Errorf("%s %q: expected protected %v, got %v", test.kind, test.name, test.protected, protected)
+		}
+	}
+}
+
+func TestFilterDeletions(t *testing.T) {
+	saved := Safeguards
+	t.Cleanup(func() { Safeguards = saved })
+	Safeguards = TSafeguards{MaxDeletions: 3}
+	limit := 2
+	tests := []struct {
+		name    string
+		host    *THost
+		deleted int
+		names   TListOfStrings
+		allowed int
+		fails   bool
+	}{
+		{"within the limit", &THost{Login: "rosman"}, 0, TListOfStrings{"a", "b", "c"}, 3, false},
+		{"protected objects do not count", &THost{Login: "rosman"}, 0, TListOfStrings{"a", "b", "c", "rosman"}, 3, false},
+		{"earlier steps count", &THost{Login: "rosman"}, 2, TListOfStrings{"a", "b"}, 2, true},
+		{"host limit overrides", &THost{Login: "rosman", MaxDeletions: &limit}, 0, TListOfStrings{"a", "b", "c"}, 3, true},
+		{"forced", &THost{Login: "rosman", options: TRunOptions{ForceDeletions: true}}, 0, TListOfStrings{"a", "b", "c", "d"}, 4, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.host.run = &TRun{}
+			step := &TStepResult{Name: "clean_users"}
+			for i := 0; i < test.deleted; i++ {
+				step.Deleted = append(step.Deleted, &TObjectRef{Kind: "group", Name: "old"})
+			}
+			test.host.run.Steps = append(test.host.run.Steps, step)
+			allowed, err := test.host.FilterDeletions("user", test.names)
+			if len(allowed) != test.allowed {
+				t.Errorf("expected %d allowed, got %v", test.allowed, allowed)
+			}
+			if (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+			if err != nil && ClassifyError(err) != ErrorPermanent {
+				t.Errorf("exceeded limit is retried: %v", err)
+			}
+		})
+	}
+}
+
+func TestSafeguardsLoad(t *testing.T) {
+	savedHosts := Hosts
+	t.Cleanup(func() { Hosts = savedHosts })
+	negative := -1
+	tests := []struct {
+		name       string
+		safeguards TSafeguards
+		host       *THost
+		fails      bool
+	}{
+		{"valid", TSafeguards{ProtectedUsers: TListOfStrings{"svc-*"}, MaxDeletions: 5, UnknownUsers: UnknownUsersDisable}, &THost{}, false},
+		{"bad pattern", TSafeguards{ProtectedGroups: TListOfStrings{"[ops"}}, &THost{}, true},
+		{"bad host pattern", TSafeguards{}, &THost{ProtectedSchedules: TListOfStrings{"[backup"}}, true},
+		{"negative limit", TSafeguards{MaxDeletions: -1}, &THost{}, true},
+		{"negative host limit", TSafeguards{}, &THost{MaxDeletions: &negative}, true},
+		{"unknown policy", TSafeguards{UnknownUsers: "ignore"}, &THost{}, true},
+		{"unknown host policy", TSafeguards{}, &THost{UnknownUsers: "ignore"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			Hosts = THosts{test.host}
+			err := test.safeguards.Load()
+			if (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package mikrotik
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TSecretStore struct {
+	Secrets map[string][]*TSecretVersion `json:"secrets"`
+	key     []byte
+	path    string
+}
+
+type TSecretVersion struct {
+	Version  int        `json:"version"`
+	Created  time.Time  `json:"created"`
+	Revealed *time.Time `json:"revealed,omitempty"`
+	Nonce    string     `json:"nonce"`
+	Data     string     `json:"data"`
+}
+
+const secretsKeyEnv = "ROSMAN_SECRETS_KEY"
+
+var ErrSecretsDisabled = errors.New("secrets store is not configured, set param \"secrets_key_file\" or " + secretsKeyEnv)
+
+var secretsMutex sync.Mutex
+
+// LoadSecretsKey reads the 32 byte store key as hex or base64 from the
+// environment or from the key file.
+func LoadSecretsKey() ([]byte, error) {
+	encoded := strings.TrimSpace(os.Getenv(secretsKeyEnv))
+	if encoded == "" {
+		path := Params.GetValue("secrets_key_file")
+		if path == "" {
+			return nil, ErrSecretsDisabled
+		}
+		content, err := ioutil.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrSecretsDisabled
+		}
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("secrets key must be 32 bytes encoded as hex or base64")
+	}
+	return key, nil
+}
+
+func GenerateSecretsKey(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New(fmt.Sprintf("key file \"%s\" already exists", path))
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+func openSecrets() (*TSecretStore, error) {
+	key, err := LoadSecretsKey()
+	if err != nil {
+		return nil, err
+	}
+	store := &TSecretStore{Secrets: map[string][]*TSecretVersion{}, key: key, path: Params.GetValue("file_secrets")}
+	if store.path == "" {
+		return nil, ErrSecretsDisabled
+	}
+	content, err := ioutil.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, store)
+	if err != nil {
+		return nil, err
+	}
+	if store.Secrets == nil {
+		store.Secrets = map[string][]*TSecretVersion{}
+	}
+	return store, nil
+}
+
+func (store *TSecretStore) save() error {
+	content, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(store.path+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(store.path+".tmp", store.path)
+}
+
+func (store *TSecretStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(store.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The secret name and version are authenticated as additional data, so a
+// ciphertext moved to another name or version fails to decrypt.
+func secretAdditionalData(name string, version int) []byte {
+	return []byte(fmt.Sprintf("rosman:%s:%d", name, version))
+}
+
+func (store *TSecretStore) put(name string, value string) (int, error) {
+	aead, err := store.aead()
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if versions := store.Secrets[name]; len(versions) > 0 {
+		version = versions[len(versions)-1].Version + 1
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return 0, err
+	}
+	data := aead.Seal(nil, nonce, []byte(value), secretAdditionalData(name, version))
+	store.Secrets[name] = append(store.Secrets[name], &TSecretVersion{
+		Version: version,
+		Created: time.Now(),
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		Data:    base64.StdEncoding.EncodeToString(data),
+	})
+	return version, store.save()
+}
+
+func (store *TSecretStore) get(name string, version int) (string, *TSecretVersion, error) {
+	versions := store.Secrets[name]
+	if len(versions) == 0 {
+		return "", nil, errors.New(fmt.Sprintf("secret \"%s\" does not exist", name))
+	}
+	entry := versions[len(versions)-1]
+	if version > 0 {
+		entry = nil
+		for _, candidate := range versions {
+			if candidate.Version == version {
+				entry = candidate
+			}
+		}
+		if entry == nil {
+			return "", nil, errors.New(fmt.Sprintf("secret \"%s\" has no version %d", name, version))
+		}
+	}
+	aead, err := store.aead()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(entry.Nonce)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(entry.Data)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := aead.Open(nil, nonce, data, secretAdditionalData(name, entry.Version))
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("secret \"%s\" can not be decrypted, wrong key or damaged store", name))
+	}
+	return string(value), entry, nil
+}
+
+// PutSecret stores a new version of the secret and returns its number.
+func PutSecret(name string, value string) (int, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	store, err := openSecrets()
+	if err != nil {
+		return 0, err
+	}
+	return store.put(name, value)
+}
+
+// GetSecret returns the given version of the secret, 0 means the latest one.
+func GetSecret(name string, version int) (string, *TSecretVersion, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	store, err := openSecrets()
+	if err != nil {
+		return "", nil, err
+	}
+	return store.get(name, version)
+}
+
+func MarkSecretRevealed(name string, version int) error {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	store, err := openSecrets()
+	if err != nil {
+		return err
+	}
+	for _, entry := range store.Secrets[name] {
+		if entry.Version == version {
+			now := time.Now()
+			entry.Revealed = &now
+			return store.save()
+		}
+	}
+	return errors.New(fmt.Sprintf("secret \"%s\" has no version %d", name, version))
+}
+
+func ListSecrets() (map[string][]*TSecretVersion, []string, error) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	store, err := openSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	for name := range store.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return store.Secrets, names, nil
+}
+
+func UserSecretName(login string, ip string) string {
+	return "user/" + login + "@" + ip
+}
+
+// CheckSecretsStore fails on load when the config makes rosman generate
+// passwords and there is no store to keep them in.
+func CheckSecretsStore() error {
+	var reason string
+	for _, user := range Users {
+		if user.Pass == "" {
+			reason = fmt.Sprintf("user \"%s\" has no password", user.Alias)
+			break
+		}
+		if user.RotateDays > 0 {
+			reason = fmt.Sprintf("user \"%s\" has rotate_days", user.Alias)
+			break
+		}
+	}
+	if reason == "" && Breakglass.Enabled {
+		reason = "breakglass is enabled"
+	}
+	if reason == "" {
+		return nil
+	}
+	_, err := LoadSecretsKey()
+	if err == nil && Params.GetValue("file_secrets") == "" {
+		err = ErrSecretsDisabled
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s, generated passwords need the secrets store: %s", reason, err))
+	}
+	return nil
+}
+
+// EscrowPassword stores a generated password before it is sent to the device,
+// a password that can not be stored is not used.
+func (host *THost) EscrowPassword(login string, password string) error {
+	name := UserSecretName(login, host.IP)
+	version, err := PutSecret(name, password)
+	if errors.Is(err, ErrSecretsDisabled) {
+		return &TPermanentError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	host.Log().Info("generated password escrowed", "user", login, "secret", name, "version", version)
+	return nil
+}
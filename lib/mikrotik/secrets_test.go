@@ -0,0 +1,140 @@
+package mikrotik
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useSecrets(t *testing.T) string {
+	t.Helper()
+	t.Setenv(secretsKeyEnv, "")
+	dir := useParams(t, map[string]string{"secrets_key_file": "key", "file_secrets": "secrets.json"})
+	err := GenerateSecretsKey(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSecretsRoundTrip(t *testing.T) {
+	dir := useSecrets(t)
+	for i, value := range []string{"first", "second"} {
+		version, err := PutSecret("user/admin@10.0.0.1", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != i+1 {
+			t.Errorf("expected version %d, got %d", i+1, version)
+		}
+	}
+	tests := []struct {
+		version int
+		value   string
+	}{{0, "second"}, {1, "first"}, {2, "second"}}
+	for _, test := range tests {
+		value, entry, err := GetSecret("user/admin@10.0.0.1", test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != test.value {
+			t.Errorf("version %d: expected %q, got %q", test.version, test.value, value)
+		}
+		if test.version != 0 && entry.Version != test.version {
+			t.Errorf("expected entry of version %d, got %d", test.version, entry.Version)
+		}
+	}
+	if _, _, err := GetSecret("user/admin@10.0.0.1", 3); err == nil {
+		t.Error("missing version is returned")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "secrets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "first") || strings.Contains(string(content), "second") {
+		t.Error("store contains the plain text")
+	}
+}
+
+func TestSecretsWrongKey(t *testing.T) {
+	dir := useSecrets(t)
+	_, err := PutSecret("breakglass/10.0.0.1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GenerateSecretsKey(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := GetSecret("breakglass/10.0.0.1", 0); err == nil || !strings.Contains(err.Error(), "can not be decrypted") {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+}
+
+func TestSecretsAdditionalData(t *testing.T) {
+	dir := useSecrets(t)
+	for _, name := range []string{"user/a@10.0.0.1", "user/b@10.0.0.1"} {
+		if _, err := PutSecret(name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "secrets.json")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var store TSecretStore
+	if err = json.Unmarshal(content, &store); err != nil {
+		t.Fatal(err)
+	}
+	store.Secrets["user/a@10.0.0.1"] = store.Secrets["user/b@10.0.0.1"]
+	content, _ = json.Marshal(&store)
+	if err = ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := GetSecret("user/a@10.0.0.1", 0); err == nil {
+		t.Error("ciphertext moved to another name is decrypted")
+	}
+}
+
+func TestSecretsDisabled(t *testing.T) {
+	t.Setenv(secretsKeyEnv, "")
+	useParams(t, map[string]string{"secrets_key_file": "missing", "file_secrets": "secrets.json"})
+	if _, err := PutSecret("name", "value"); err != ErrSecretsDisabled {
+		t.Errorf("expected ErrSecretsDisabled, got %v", err)
+	}
+	t.Setenv(secretsKeyEnv, "short")
+	if _, err := LoadSecretsKey(); err == nil || err == ErrSecretsDisabled {
+		t.Errorf("expected a key error, got %v", err)
+	}
+}
+
+func TestCheckSecretsStore(t *testing.T) {
+	users := Users
+	t.Cleanup(func() { Users = users })
+	t.Setenv(secretsKeyEnv, "")
+	useParams(t, map[string]string{"secrets_key_file": "missing", "file_secrets": "secrets.json"})
+	Users = TUsers{{Alias: "fixed", Login: "fixed", Pass: "password"}}
+	if err := CheckSecretsStore(); err != nil {
+		t.Errorf("store is required without generated passwords: %s", err)
+	}
+	Users = append(Users, &TUser{Alias: "generated", Login: "generated"})
+	if err := CheckSecretsStore(); err == nil {
+		t.Error("user without password is accepted without a store")
+	}
+	host := &THost{Name: "router", IP: "10.0.0.1"}
+	if err := host.EscrowPassword("generated", "secret"); ClassifyError(err) != ErrorPermanent {
+		t.Errorf("password is used without a store: %v", err)
+	}
+	useSecrets(t)
+	if err := CheckSecretsStore(); err != nil {
+		t.Errorf("configured store is rejected: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/routeros.v2"
+)
+
+func HostSecretName(ip string) string {
+	return "host/" + ip + "/login"
+}
+
+// GetPassword prefers the password stored by rotate-self over hosts.json, so a
+// running service follows a rotation done from the command line.
+func (host *THost) GetPassword() string {
+	name := HostSecretName(host.IP)
+	secrets, _, err := ListSecrets()
+	if err != nil || len(secrets[name]) == 0 {
+		return host.Pass
+	}
+	password, _, err := GetSecret(name, 0)
+	if err != nil {
+		host.Log().Error("stored login password can not be read, using hosts.json", "secret", name, "error", err)
+		return host.Pass
+	}
+	return password
+}
+
+// VerifyLogin opens new API and SSH sessions with the password, the cached
+// connections of the host are not used.
+func (host *THost) VerifyLogin(password string) error {
+	connApi, err := routeros.Dial(fmt.Sprintf("%s:%d", host.IP, host.PortAPI), host.Login, password)
+	if err != nil {
+		return errors.New(fmt.Sprintf("API login failed: %s", err))
+	}
+	_, err = connApi.Run("/system/identity/print")
+	connApi.Close()
+	if err != nil {
+		return errors.New(fmt.Sprintf("API login failed: %s", err))
+	}
+	if host.PortSSH == 0 {
+		return nil
+	}
+	config := host.GetSshClientConfig()
+	config.Auth = []ssh.AuthMethod{ssh.Password(password)}
+	connSSH, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host.IP, host.PortSSH), config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("SSH login failed: %s", err))
+	}
+	return connSSH.Close()
+}
+
+// RotateSelf changes the password of the rosman login on the device. The new
+// password is committed to the store only after a fresh login succeeds,
+// otherwise the previous password is restored. It is escrowed as pending
+// before the device is touched, so an interrupted rotation never loses it.
+// Jobs of a running service wait for it on the host lock.
+func (host *THost) RotateSelf() error {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	lock, err := host.LockHost()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	defer host.Disconnect()
+	name := HostSecretName(host.IP)
+	current := host.GetPassword()
+	err = host.VerifyLogin(current)
+	if err != nil {
+		return err
+	}
+	generated := TUser{Login: host.Login}
+	err = generated.GeneratePassword()
+	if err != nil {
+		return err
+	}
+	_, err = PutSecret(name+"/pending", generated.Pass)
+	if err != nil {
+		return err
+	}
+	host.Log().Info("setting new login password", "user", host.Login)
+	_, err = host.RunAPI("/user/set", "=numbers="+host.Login, "=password="+generated.Pass)
+	if err != nil {
+		return err
+	}
+	err = host.VerifyLogin(generated.Pass)
+	if err == nil {
+		var version int
+		version, err = PutSecret(name, generated.Pass)
+		if err == nil {
+			host.Log().Info("login password rotated", "user", host.Login, "secret", name, "version", version)
+			return nil
+		}
+	}
+	host.Log().Error("new login password is not usable, rolling back", "user", host.Login, "error", err)
+	_, errRollback := host.RunAPI("/user/set", "=numbers="+host.Login, "=password="+current)
+	if errRollback == nil {
+		errRollback = host.VerifyLogin(current)
+	}
+	if errRollback != nil {
+		return &TPermanentError{Err: errors.New(fmt.Sprintf("%s; rollback failed: %s, the password set on the device is in secret \"%s/pending\"", err, errRollback, name))}
+	}
+	host.Log().Info("previous login password restored", "user", host.Login)
+	return err
+}
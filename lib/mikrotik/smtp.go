@@ -0,0 +1,221 @@
+package mikrotik
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type TSMTP struct {
+	Enabled    bool           `json:"enabled"`
+	Server     string         `json:"server"`
+	Port       int            `json:"port"`
+	TLS        string         `json:"tls"`
+	SkipVerify bool           `json:"skip_verify"`
+	Username   string         `json:"username"`
+	Password   string         `json:"password"`
+	From       string         `json:"from"`
+	Recipients TListOfStrings `json:"recipients"`
+	Events     TListOfStrings `json:"events"`
+	Subject    string         `json:"subject"`
+	Template   string         `json:"template"`
+	Timeout    int            `json:"timeout"`
+	Digest     *TDigestConfig `json:"digest"`
+	Note       string         `json:"note"`
+	subject    *template.Template
+	body       *template.Template
+}
+
+const (
+	SMTPTLSNone     = "none"
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+)
+
+const DefaultMailSubject = `[rosman] {{.Type}} {{.Host}}{{if .Job}} / {{.Job}}{{end}}`
+
+const DefaultMailTemplate = `Event:   {{.Type}}
+Host:    {{.Host}} ({{.IP}})
+{{- if .Job}}
+Job:     {{.Job}}{{end}}
+{{- if .RunID}}
+Run:     {{.RunID}}{{end}}
+
+{{.Message}}
+{{range $name, $value := .Data}}
+{{$name}}: {{$value}}{{end}}
+`
+
+var SMTP TSMTP
+
+func init() {
+	AddListener(NotifyMail)
+}
+
+func (config *TSMTP) Load() error {
+	if !config.Enabled {
+		return nil
+	}
+	if config.Server == "" || config.From == "" {
+		return errors.New("smtp: server and from are required")
+	}
+	switch config.TLS {
+	case "":
+		config.TLS = SMTPTLSStartTLS
+	case SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		return errors.New(fmt.Sprintf("smtp: tls mode \"%s\" does not exist", config.TLS))
+	}
+	if config.Port == 0 {
+		config.Port = 587
+		if config.TLS == SMTPTLSImplicit {
+			config.Port = 465
+		}
+	}
+	var err error
+	config.subject, err = parseMailTemplate("subject", config.Subject, DefaultMailSubject)
+	if err != nil {
+		return err
+	}
+	config.body, err = parseMailTemplate("template", config.Template, DefaultMailTemplate)
+	if err != nil {
+		return err
+	}
+	return config.Digest.Load()
+}
+
+func parseMailTemplate(name string, source string, fallback string) (*template.Template, error) {
+	if source == "" {
+		source = fallback
+	}
+	parsed, err := template.New(name).Parse(source)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("smtp: %s: %s", name, err))
+	}
+	return parsed, nil
+}
+
+func NotifyMail(event TEvent) {
+	if !SMTP.Enabled || !(SMTP.Events.IsContain(WebhookEventAll) || SMTP.Events.IsContain(event.Type)) {
+		return
+	}
+	Deliver(func() {
+		var subject, body bytes.Buffer
+		err := SMTP.subject.Execute(&subject, event)
+		if err == nil {
+			err = SMTP.body.Execute(&body, event)
+		}
+		if err == nil {
+			err = SMTP.Send(SMTP.Recipients, subject.String(), body.String())
+		}
+		if err != nil {
+			Log.Error("mail error", "component", "smtp", "event", event.Type, "host", event.Host, "error", err)
+		}
+	})
+}
+
+func (config *TSMTP) Send(recipients TListOfStrings, subject string, body string) error {
+	if len(recipients) == 0 {
+		return errors.New("smtp: no recipients")
+	}
+	message := config.Compose(recipients, subject, body)
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	address := net.JoinHostPort(config.Server, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{ServerName: config.Server, InsecureSkipVerify: config.SkipVerify}
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: time.Duration(timeout) * time.Second}
+	if config.TLS == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	client, err := smtp.NewClient(conn, config.Server)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	if config.TLS == SMTPTLSStartTLS {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Server))
+		if err != nil {
+			return &TPermanentError{Err: err}
+		}
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return &TPermanentError{Err: err}
+	}
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		to, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return &TPermanentError{Err: err}
+		}
+		err = client.Rcpt(to.Address)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	Log.Info("mail sent", "component", "smtp", "subject", subject, "recipients", strings.Join(recipients, ","))
+	return client.Quit()
+}
+
+func (config *TSMTP) Compose(recipients TListOfStrings, subject string, body string) []byte {
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	domain := "rosman"
+	if from, err := mail.ParseAddress(config.From); err == nil {
+		domain = from.Address[strings.LastIndex(from.Address, "@")+1:]
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(random), domain)
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return message.Bytes()
+}
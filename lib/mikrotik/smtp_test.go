@@ -0,0 +1,227 @@
+package mikrotik
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tSMTPServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	commands []string
+	messages []string
+	reject   string
+}
+
+// newSMTPServer accepts plain SMTP on a local port and keeps the commands and
+// messages it receives. A command starting with reject is answered with 550.
+func newSMTPServer(t *testing.T, reject string) *tSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &tSMTPServer{listener: listener, reject: reject}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *tSMTPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		server.mutex.Lock()
+		server.commands = append(server.commands, command)
+		server.mutex.Unlock()
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		switch {
+		case server.reject != "" && strings.HasPrefix(command, server.reject):
+			reply("550 rejected")
+		case verb == "EHLO" || verb == "HELO":
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case verb == "DATA":
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			server.mutex.Lock()
+			server.messages = append(server.messages, message.String())
+			server.mutex.Unlock()
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (server *tSMTPServer) config() *TSMTP {
+	address := server.listener.Addr().(*net.TCPAddr)
+	config := &TSMTP{Enabled: true, Server: "127.0.0.1", Port: address.Port, TLS: SMTPTLSNone, From: "rosman <rosman@example.com>", Timeout: 5}
+	return config
+}
+
+func TestSMTPSend(t *testing.T) {
+	server := newSMTPServer(t, "")
+	config := server.config()
+	err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = config.Send(TListOfStrings{"officer@example.com", "Ops <ops@example.com>"}, "[rosman] digest", "line one\nline two\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, expected := range []string{"MAIL FROM:<rosman@example.com>", "RCPT TO:<officer@example.com>", "RCPT TO:<ops@example.com>"} {
+		if !TListOfStrings(server.commands).IsContain(expected) && !containsPrefix(server.commands, expected) {
+			t.Errorf("command %q was not sent: %v", expected, server.commands)
+		}
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(server.messages))
+	}
+	message := server.messages[0]
+	for _, expected := range []string{"From: rosman <rosman@example.com>\r\n", "To: officer@example.com, Ops <ops@example.com>\r\n", "Subject: [rosman] digest\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("message does not contain %q:\n%s", expected, message)
+		}
+	}
+}
+
+func TestSMTPErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		reject     string
+		recipients TListOfStrings
+		permanent  bool
+	}{
+		{"no recipients", "", nil, false},
+		{"invalid recipient", "", TListOfStrings{"not an address"}, true},
+		{"rejected recipient", "RCPT", TListOfStrings{"officer@example.com"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t, test.reject)
+			config := server.config()
+			err := config.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = config.Send(test.recipients, "subject", "body")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if (ClassifyError(err) == ErrorPermanent) != test.permanent {
+				t.Errorf("unexpected class %s of %v", ClassifyError(err), err)
+			}
+		})
+	}
+}
+
+func TestSMTPLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TSMTP
+		port   int
+		fails  bool
+	}{
+		{"disabled is not checked", TSMTP{}, 0, false},
+		{"starttls by default", TSMTP{Enabled: true, Server: "mail", From: "a@b"}, 587, false},
+		{"implicit tls port", TSMTP{Enabled: true, Server: "mail", From: "a@b", TLS: SMTPTLSImplicit}, 465, false},
+		{"unknown tls mode", TSMTP{Enabled: true, Server: "mail", From: "a@b", TLS: "ssl"}, 0, true},
+		{"server is required", TSMTP{Enabled: true, From: "a@b"}, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Load()
+			if (err != nil) != test.fails {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if err == nil && test.config.Port != test.port {
+				t.Errorf("expected port %d, got %d", test.port, test.config.Port)
+			}
+		})
+	}
+}
+
+func containsPrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildDigest(t *testing.T) {
+	useParams(t, map[string]string{"file_history": "history.jsonl"})
+	start := time.Now().Add(-time.Hour)
+	runs := []*TRun{
+		{ID: "1", Host: "router", IP: "10.0.0.1", Job: "sync", Status: StatusSuccess, Start: start, Steps: TStepResults{
+			{Name: "clean_users", Deleted: []*TObjectRef{{Kind: "user", Name: "bob"}, {Kind: "user", Name: "carol"}}},
+			{Name: "add_users", Created: []*TObjectRef{{Kind: "user", Name: "bob"}, {Kind: "user", Name: "dave"}}},
+			{Name: "add_groups", Updated: []*TObjectRef{{Kind: "group", Name: "ops", Reason: "policy"}}},
+		}},
+		{ID: "2", Host: "router", IP: "10.0.0.1", Job: "rotate", Status: StatusFailed, Error: "timeout", Start: start.Add(time.Minute), Steps: TStepResults{
+			{Name: "rotate_passwords", Rotated: []*TObjectRef{{Kind: "user", Name: "alice", Reason: "version 2"}}},
+			{Name: "sync_networks", Updated: []*TObjectRef{{Kind: "user", Name: "alice", Reason: "address"}}},
+		}},
+	}
+	for _, run := range runs {
+		if err := AppendHistory(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digest, err := BuildDigest(start.Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Runs != 2 || digest.Failed != 1 || len(digest.Failing) != 1 {
+		t.Errorf("unexpected totals: %d runs, %d failed", digest.Runs, digest.Failed)
+	}
+	config := &TDigestConfig{}
+	if err := config.ParseTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := config.Render(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"users added: dave", "users removed: carol", "users replaced: bob", "users updated: alice", "users rotated: alice", "groups updated: ops", "job rotate: timeout"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("digest does not report %q:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package mikrotik
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strings"
+)
+
+type TSshKey struct {
+	Source      string
+	Fingerprint string
+	Comment     string
+	Line        string
+	material    []byte
+}
+
+type TDeviceKey struct {
+	ID          string
+	User        string
+	Owner       string
+	Fingerprint string
+	Key         string
+	reason      string
+}
+
+// ParseSshKey accepts an inline public key or a file name in dir_ssh-pub-keys.
+func ParseSshKey(source string) (*TSshKey, error) {
+	content := []byte(source)
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		content, err = ioutil.ReadFile(Params.GetValue("dir_ssh-pub-keys") + source)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, comment, _, _, err = ssh.ParseAuthorizedKey(content)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("key file \"%s\": %s", source, err))
+		}
+	}
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+	// RouterOS shows the key comment as key-owner, the fingerprint is put there
+	// so that imported keys can be matched on versions that do not print one.
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))) + " " + fingerprint
+	return &TSshKey{Source: source, Fingerprint: fingerprint, Comment: comment, Line: line, material: publicKey.Marshal()}, nil
+}
+
+// parseKeyMaterial reads the public key printed by the device, either a whole
+// authorized_keys line or only its base64 part.
+func parseKeyMaterial(value string) []byte {
+	for _, field := range strings.Fields(value) {
+		data, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			continue
+		}
+		if publicKey, err := ssh.ParsePublicKey(data); err == nil {
+			return publicKey.Marshal()
+		}
+	}
+	return nil
+}
+
+func (user *TUser) GetKeySources() TListOfStrings {
+	var sources TListOfStrings
+	if user.Key != "" {
+		sources = append(sources, user.Key)
+	}
+	return append(sources, user.Keys...)
+}
+
+func (users TUsers) LoadKeys() error {
+	for _, user := range users {
+		user.sshKeys = nil
+		for _, source := range user.GetKeySources() {
+			key, err := ParseSshKey(source)
+			if err != nil {
+				return errors.New(fmt.Sprintf("user \"%s\": %s", user.Alias, err))
+			}
+			user.sshKeys = append(user.sshKeys, key)
+		}
+	}
+	return nil
+}
+
+func (user *TUser) GetKeyFingerprints() TListOfStrings {
+	var fingerprints TListOfStrings
+	for _, key := range user.sshKeys {
+		fingerprints = append(fingerprints, key.Fingerprint)
+	}
+	return fingerprints
+}
+
+// MatchKey finds the configured key of a device key by the public key when the
+// device prints it, then by fingerprint, then by key-owner: the fingerprint
+// rosman imports with or, for keys imported before, the comment of the key
+// file. A comment shared by several configured keys matches none of them.
+// The second result is false when only the comment matched.
+func (user *TUser) MatchKey(deviceKey *TDeviceKey) (*TSshKey, bool) {
+	if material := parseKeyMaterial(deviceKey.Key); material != nil {
+		for _, key := range user.sshKeys {
+			if bytes.Equal(material, key.material) {
+				return key, true
+			}
+		}
+		return nil, true
+	}
+	if deviceKey.Fingerprint != "" {
+		fingerprint := "SHA256:" + strings.TrimPrefix(deviceKey.Fingerprint, "SHA256:")
+		for _, key := range user.sshKeys {
+			if fingerprint == key.Fingerprint {
+				return key, true
+			}
+		}
+		return nil, true
+	}
+	var matched *TSshKey
+	for _, key := range user.sshKeys {
+		if deviceKey.Owner == key.Fingerprint {
+			return key, true
+		}
+		if key.Comment != "" && deviceKey.Owner == key.Comment {
+			if matched != nil {
+				return nil, false
+			}
+			matched = key
+		}
+	}
+	return matched, false
+}
+
+func (host *THost) GetSshKeys() ([]*TDeviceKey, error) {
+	var keys []*TDeviceKey
+	res, err := host.RunAPI("/user/ssh-keys/print")
+	if err != nil {
+		return keys, err
+	}
+	for _, el := range res.Re {
+		keys = append(keys, &TDeviceKey{
+			ID:          el.Map[".id"],
+			User:        el.Map["user"],
+			Owner:       el.Map["key-owner"],
+			Fingerprint: el.Map["fingerprint"],
+			Key:         el.Map["key"],
+		})
+	}
+	return keys, nil
+}
+
+// SyncSshKeys removes device keys of managed users that are not configured,
+// duplicates included, and imports configured keys that are missing. All
+// removals of the run pass the deletion safeguards before the first of them.
+func (host *THost) SyncSshKeys() error {
+	users, err := host.GetUsers()
+	if err != nil {
+		return err
+	}
+	deviceKeys, err := host.GetSshKeys()
+	if err != nil {
+		return err
+	}
+	var remove []*TDeviceKey
+	var names TListOfStrings
+	missing := map[string][]*TSshKey{}
+	for _, user := range host.Users {
+		if !TUsers(users).IsContain(user.Login) {
+			continue
+		}
+		var keys []*TDeviceKey
+		for _, deviceKey := range deviceKeys {
+			if deviceKey.User == user.Login {
+				keys = append(keys, deviceKey)
+			}
+		}
+		extra, absent := host.PlanUserKeys(user, keys)
+		for _, deviceKey := range extra {
+			remove = append(remove, deviceKey)
+			names = append(names, deviceKey.GetName())
+		}
+		missing[user.Login] = absent
+	}
+	allowed, err := host.FilterDeletions("ssh_key", names)
+	if err != nil {
+		return err
+	}
+	for _, deviceKey := range remove {
+		if !allowed.IsContain(deviceKey.GetName()) {
+			continue
+		}
+		err = host.RemoveSshKey(deviceKey, deviceKey.reason)
+		if err != nil {
+			return err
+		}
+	}
+	for _, user := range host.Users {
+		for _, key := range missing[user.Login] {
+			err = host.AddSshKey(user.Login, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PlanUserKeys returns the device keys of the user that are not configured and
+// the configured keys the device lacks. A key matched only by comment may be
+// any key with that comment, so it is removed and the configured key is
+// imported again with its fingerprint.
+func (host *THost) PlanUserKeys(user *TUser, deviceKeys []*TDeviceKey) ([]*TDeviceKey, []*TSshKey) {
+	var remove []*TDeviceKey
+	var missing []*TSshKey
+	present := map[string]bool{}
+	for _, deviceKey := range deviceKeys {
+		key, exact := user.MatchKey(deviceKey)
+		switch {
+		case key != nil && exact && !present[key.Fingerprint]:
+			present[key.Fingerprint] = true
+			continue
+		case key != nil && !exact:
+			host.Log().Warn("key matched only by comment is replaced", "user", user.Login, "key", deviceKey.Owner)
+			deviceKey.reason = "matched only by comment"
+		default:
+			deviceKey.reason = "not in config"
+		}
+		remove = append(remove, deviceKey)
+	}
+	for _, key := range user.sshKeys {
+		if present[key.Fingerprint] {
+			host.Log().Debug("user already has key", "user", user.Login, "key", key.Fingerprint)
+			continue
+		}
+		missing = append(missing, key)
+	}
+	return remove, missing
+}
+
+// AddUserKeys imports the keys of a user the device has just created.
+func (host *THost) AddUserKeys(user *TUser) error {
+	for _, key := range user.sshKeys {
+		err := host.AddSshKey(user.Login, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (host *THost) AddSshKey(login string, key *TSshKey) error {
+	hash := sha256.Sum256([]byte(key.Fingerprint))
+	file := fmt.Sprintf("rosman-%s-%s.pub", MakeAlias(login), hex.EncodeToString(hash[:4]))
+	err := host.UploadKey(file, key.Line+"\n")
+	if err != nil {
+		return err
+	}
+	err = host.ImportSshKey(login, file)
+	if err != nil {
+		return err
+	}
+	host.RecordCreated("ssh_key", login+" "+key.Fingerprint)
+	return nil
+}
+
+func (deviceKey *TDeviceKey) GetName() string {
+	return deviceKey.User + " " + deviceKey.Owner
+}
+
+func (host *THost) RemoveSshKey(deviceKey *TDeviceKey, reason string) error {
+	name := deviceKey.GetName()
+	err := host.RunHooks(HookOnDeletion, map[string]string{"kind": "ssh_key", "name": name, "reason": reason})
+	if err != nil {
+		host.Log().Warn("deletion skipped", "ssh_key", name, "error", err)
+		return nil
+	}
+	host.Log().Info("delete ssh key", "user", deviceKey.User, "key", deviceKey.Owner, "reason", reason)
+	_, err = host.RunAPI("/user/ssh-keys/remove", "=numbers="+deviceKey.ID)
+	if err != nil {
+		return err
+	}
+	host.RecordDeleted("ssh_key", name, reason)
+	return nil
+}
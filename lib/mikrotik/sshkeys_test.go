@@ -0,0 +1,92 @@
+package mikrotik
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func newSshKey(t *testing.T, comment string) *TSshKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseSshKey(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))) + " " + comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestMatchKey(t *testing.T) {
+	laptop := newSshKey(t, "alice@laptop")
+	desktop := newSshKey(t, "alice@desktop")
+	other := newSshKey(t, "alice@laptop")
+	user := &TUser{Login: "alice", sshKeys: []*TSshKey{laptop, desktop}}
+	tests := []struct {
+		name      string
+		deviceKey *TDeviceKey
+		expected  *TSshKey
+		exact     bool
+	}{
+		{"key material", &TDeviceKey{Key: strings.Fields(desktop.Line)[1], Owner: "alice@laptop"}, desktop, true},
+		{"key line", &TDeviceKey{Key: desktop.Line}, desktop, true},
+		{"other key material with the same comment", &TDeviceKey{Key: other.Line, Owner: "alice@laptop"}, nil, true},
+		{"fingerprint", &TDeviceKey{Fingerprint: strings.TrimPrefix(laptop.Fingerprint, "SHA256:")}, laptop, true},
+		{"fingerprint as key owner", &TDeviceKey{Owner: desktop.Fingerprint}, desktop, true},
+		{"comment of an old import", &TDeviceKey{Owner: "alice@desktop"}, desktop, false},
+		{"unknown comment", &TDeviceKey{Owner: "bob@laptop"}, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, exact := user.MatchKey(test.deviceKey)
+			if key != test.expected || exact != test.exact {
+				t.Errorf("expected %v %v, got %v %v", test.expected, test.exact, key, exact)
+			}
+		})
+	}
+	shared := &TUser{Login: "alice", sshKeys: []*TSshKey{laptop, other}}
+	if key, _ := shared.MatchKey(&TDeviceKey{Owner: "alice@laptop"}); key != nil {
+		t.Errorf("comment shared by two keys matched %s", key.Fingerprint)
+	}
+}
+
+func TestPlanUserKeys(t *testing.T) {
+	laptop := newSshKey(t, "alice@laptop")
+	desktop := newSshKey(t, "alice@desktop")
+	revoked := newSshKey(t, "alice@old")
+	user := &TUser{Login: "alice", sshKeys: []*TSshKey{laptop, desktop}}
+	host := &THost{IP: "10.0.0.1", Login: "rosman"}
+	deviceKeys := []*TDeviceKey{
+		{ID: "*1", User: "alice", Owner: laptop.Fingerprint},
+		{ID: "*2", User: "alice", Owner: laptop.Fingerprint},
+		{ID: "*3", User: "alice", Owner: "alice@old"},
+		{ID: "*4", User: "alice", Owner: "alice@old", Key: revoked.Line},
+		{ID: "*5", User: "alice", Owner: "alice@desktop"},
+	}
+	remove, missing := host.PlanUserKeys(user, deviceKeys)
+	var ids TListOfStrings
+	for _, deviceKey := range remove {
+		ids = append(ids, deviceKey.ID)
+	}
+	if strings.Join(ids, ",") != "*2,*3,*4,*5" {
+		t.Errorf("unexpected removals %v", ids)
+	}
+	if remove[3].reason != "matched only by comment" || remove[0].reason != "not in config" {
+		t.Errorf("unexpected reasons %q %q", remove[3].reason, remove[0].reason)
+	}
+	// the key matched only by comment is imported again
+	if len(missing) != 1 || missing[0] != desktop {
+		t.Errorf("unexpected missing keys %v", missing)
+	}
+	if !host.IsProtected("ssh_key", "rosman "+laptop.Fingerprint) || host.IsProtected("ssh_key", "alice "+laptop.Fingerprint) {
+		t.Error("keys of protected users are not protected")
+	}
+}
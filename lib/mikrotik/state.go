@@ -0,0 +1,322 @@
+package mikrotik
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+type TState struct {
+	Hosts          map[string]*THostState `json:"hosts"`
+	DigestLastSent int64                  `json:"digest_last_sent,omitempty"`
+	GrantsChecked  int64                  `json:"grants_checked,omitempty"`
+}
+
+type THostState struct {
+	LastSeen  int64                      `json:"last_seen"`
+	Jobs      map[string]*TJobState      `json:"jobs"`
+	Passwords map[string]*TPasswordState `json:"passwords,omitempty"`
+}
+
+type TJobState struct {
+	LastRun     int64  `json:"last_run"`
+	LastSuccess int64  `json:"last_success"`
+	LastError   string `json:"last_error"`
+	Alerting    bool   `json:"alerting"`
+}
+
+var stateMutex sync.RWMutex
+var stateFileMutex sync.Mutex
+var startedAt = time.Now().Unix()
+var digestLastSent int64
+
+// stateDirty holds the keys this process changed since the last save, only
+// they are written over the file, the rest is taken from it. The daemon and
+// CLI commands share the file.
+var stateDirty = map[string]bool{}
+
+const alertCheckInterval = 15 * time.Second
+
+func GetStatePath() string {
+	param, err := Params.GetByName("file_state")
+	if err != nil {
+		return ""
+	}
+	return param.Value
+}
+
+func LoadState() error {
+	path := GetStatePath()
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	state, err := readState(path)
+	if err != nil {
+		return err
+	}
+	Log.Info("state loaded", "file", path)
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	digestLastSent = state.DigestLastSent
+	grantsChecked = state.GrantsChecked
+	for _, host := range Hosts {
+		hostState, ok := state.Hosts[host.IP]
+		if !ok {
+			continue
+		}
+		host.LastSeen = hostState.LastSeen
+		host.passwords = hostState.Passwords
+		for _, job := range host.Jobs {
+			jobState, ok := hostState.Jobs[job.Name]
+			if !ok {
+				continue
+			}
+			job.LastRun = jobState.LastRun
+			job.LastSuccess = jobState.LastSuccess
+			job.LastError = jobState.LastError
+			job.Alerting = jobState.Alerting
+		}
+	}
+	return nil
+}
+
+// readState reads the state file without the config loaded log line, it is
+// read again on every save.
+func readState(path string) (TState, error) {
+	var state TState
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func stateJobKey(host *THost, job *TJob) string {
+	return "job/" + host.IP + "/" + job.Name
+}
+
+func statePasswordKey(host *THost, login string) string {
+	return "password/" + host.IP + "/" + login
+}
+
+// MarkState records a change to be written by the next SaveState, the caller
+// holds stateMutex.
+func MarkState(key string) {
+	stateDirty[key] = true
+}
+
+// SaveState merges the state of this process into the file under a file lock:
+// changed keys are written, the other ones are read back, last_seen keeps the
+// latest value and hosts not configured here are kept.
+func SaveState() error {
+	path := GetStatePath()
+	if path == "" {
+		return nil
+	}
+	stateFileMutex.Lock()
+	defer stateFileMutex.Unlock()
+	lock, err := LockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	state := TState{}
+	if _, err := os.Stat(path); err == nil {
+		state, err = readState(path)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Hosts == nil {
+		state.Hosts = map[string]*THostState{}
+	}
+	stateMutex.Lock()
+	state.mergeGlobal()
+	for _, host := range Hosts {
+		hostState, ok := state.Hosts[host.IP]
+		if !ok {
+			hostState = &THostState{}
+			state.Hosts[host.IP] = hostState
+		}
+		hostState.merge(host)
+	}
+	stateDirty = map[string]bool{}
+	stateMutex.Unlock()
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// mergeGlobal keeps the latest of both times, the digest is sent and grant
+// boundaries are handled once by whichever process got there first.
+func (state *TState) mergeGlobal() {
+	if digestLastSent > state.DigestLastSent {
+		state.DigestLastSent = digestLastSent
+	}
+	digestLastSent = state.DigestLastSent
+	if grantsChecked > state.GrantsChecked {
+		state.GrantsChecked = grantsChecked
+	}
+	grantsChecked = state.GrantsChecked
+}
+
+func (hostState *THostState) merge(host *THost) {
+	if host.LastSeen > hostState.LastSeen {
+		hostState.LastSeen = host.LastSeen
+	}
+	host.LastSeen = hostState.LastSeen
+	if hostState.Jobs == nil {
+		hostState.Jobs = map[string]*TJobState{}
+	}
+	for _, job := range host.Jobs {
+		jobState, ok := hostState.Jobs[job.Name]
+		if stateDirty[stateJobKey(host, job)] || !ok {
+			hostState.Jobs[job.Name] = &TJobState{
+				LastRun:     job.LastRun,
+				LastSuccess: job.LastSuccess,
+				LastError:   job.LastError,
+				Alerting:    job.Alerting,
+			}
+			continue
+		}
+		job.LastRun = jobState.LastRun
+		job.LastSuccess = jobState.LastSuccess
+		job.LastError = jobState.LastError
+		job.Alerting = jobState.Alerting
+	}
+	if hostState.Passwords == nil {
+		hostState.Passwords = map[string]*TPasswordState{}
+	}
+	for login, password := range host.passwords {
+		if stateDirty[statePasswordKey(host, login)] {
+			copied := *password
+			hostState.Passwords[login] = &copied
+		}
+	}
+	host.passwords = map[string]*TPasswordState{}
+	for login, password := range hostState.Passwords {
+		copied := *password
+		host.passwords[login] = &copied
+	}
+}
+
+func (host *THost) SetLastSeen() {
+	stateMutex.Lock()
+	host.LastSeen = time.Now().Unix()
+	stateMutex.Unlock()
+}
+
+func (host *THost) SetRunning(name string) {
+	stateMutex.Lock()
+	host.running = name
+	stateMutex.Unlock()
+}
+
+// GetManaged returns what the host is managed to, Execute replaces the users
+// at the start of every run while the API and CLI read them.
+func (host *THost) GetManaged() (TUsers, TGroups, TSchedules) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return host.Users, host.Groups, host.Schedules
+}
+
+func (host *THost) GetLastSeen() int64 {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return host.LastSeen
+}
+
+func (host *THost) SetReachable(err error) {
+	unreachable := err != nil && ClassifyError(err) == ErrorTransient
+	stateMutex.Lock()
+	changed := host.unreachable != unreachable
+	host.unreachable = unreachable
+	stateMutex.Unlock()
+	if !changed {
+		return
+	}
+	if unreachable {
+		host.Emit(EventHostUnreachable, nil, fmt.Sprintf("host is unreachable: %s", err), map[string]string{"error": err.Error()})
+	} else {
+		host.Emit(EventHostReachable, nil, "host is reachable again", nil)
+	}
+}
+
+func (host *THost) SetJobResult(job *TJob, start int64, err error) {
+	host.SetReachable(err)
+	stateMutex.Lock()
+	job.LastRun = start
+	MarkState(stateJobKey(host, job))
+	if err != nil {
+		job.LastError = err.Error()
+	} else {
+		job.LastSuccess = start
+		job.LastError = ""
+	}
+	recovered := err == nil && job.Alerting
+	if recovered {
+		job.Alerting = false
+	}
+	stateMutex.Unlock()
+	if recovered {
+		host.Emit(EventRecovered, job, fmt.Sprintf("job \"%s\" is managed successfully again", job.Name), nil)
+	}
+	err = SaveState()
+	if err != nil {
+		host.Log().Error("state error", "error", err)
+	}
+}
+
+func (host *THost) WatchAlerts() {
+	for {
+		for _, job := range host.Jobs {
+			host.CheckAlert(job)
+		}
+		time.Sleep(alertCheckInterval)
+	}
+}
+
+func (host *THost) CheckAlert(job *TJob) {
+	if job.Task.Alert <= 0 {
+		return
+	}
+	stateMutex.Lock()
+	since := job.LastSuccess
+	if since == 0 {
+		since = startedAt
+	}
+	raise := !job.Alerting && time.Now().Unix()-since > job.Task.Alert
+	if raise {
+		job.Alerting = true
+		MarkState(stateJobKey(host, job))
+	}
+	lastSeen := host.LastSeen
+	stateMutex.Unlock()
+	if !raise {
+		return
+	}
+	message := fmt.Sprintf("job \"%s\" has not succeeded for more than %d seconds", job.Name, job.Task.Alert)
+	data := map[string]string{"alert": fmt.Sprint(job.Task.Alert)}
+	if lastSeen > 0 {
+		data["last_seen"] = time.Unix(lastSeen, 0).Format(time.RFC3339)
+	}
+	host.Emit(EventAlert, job, message, data)
+	err := SaveState()
+	if err != nil {
+		host.Log().Error("state error", "error", err)
+	}
+}
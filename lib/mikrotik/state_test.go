@@ -0,0 +1,96 @@
+package mikrotik
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSaveStateMerge(t *testing.T) {
+	dir := useParams(t, map[string]string{"file_state": "state.json"})
+	path := filepath.Join(dir, "state.json")
+	hosts := Hosts
+	t.Cleanup(func() { Hosts = hosts })
+	job := &TJob{Name: "default"}
+	host := &THost{Name: "router", IP: "10.0.0.1", Jobs: TJobs{job}, LastSeen: 100}
+	Hosts = THosts{host}
+	host.SetPasswordState("alice", 1, nil)
+
+	// another process rotates bob, runs the job and knows a host not
+	// configured here
+	var state TState
+	if err := LoadJSON(&state, path); err != nil {
+		t.Fatal(err)
+	}
+	state.Hosts["10.0.0.1"].Passwords["bob"] = &TPasswordState{Version: 3}
+	state.Hosts["10.0.0.1"].Jobs["default"] = &TJobState{LastRun: 500, LastSuccess: 500}
+	state.Hosts["10.0.0.1"].LastSeen = 500
+	state.Hosts["10.0.0.2"] = &THostState{LastSeen: 42}
+	content, _ := json.Marshal(state)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	host.SetPasswordState("alice", 2, nil)
+	state = TState{}
+	if err := LoadJSON(&state, path); err != nil {
+		t.Fatal(err)
+	}
+	saved := state.Hosts["10.0.0.1"]
+	if saved.Passwords["alice"].Version != 2 || saved.Passwords["bob"] == nil || saved.Passwords["bob"].Version != 3 {
+		t.Errorf("passwords are not merged: alice %+v, bob %+v", saved.Passwords["alice"], saved.Passwords["bob"])
+	}
+	if saved.Jobs["default"].LastRun != 500 || saved.LastSeen != 500 {
+		t.Errorf("state of the other process is overwritten: %+v, last seen %d", saved.Jobs["default"], saved.LastSeen)
+	}
+	if state.Hosts["10.0.0.2"] == nil {
+		t.Error("host not configured here is dropped")
+	}
+	if job.LastRun != 500 || host.GetPasswordState("bob").Version != 3 {
+		t.Error("state of the other process is not read back")
+	}
+
+	host.SetJobResult(job, 600, nil)
+	state = TState{}
+	if err := LoadJSON(&state, path); err != nil {
+		t.Fatal(err)
+	}
+	if state.Hosts["10.0.0.1"].Jobs["default"].LastRun != 600 {
+		t.Error("changed job state is not written")
+	}
+}
+
+// TestLockFile increments a counter kept in the file from several goroutines,
+// each with its own lock file descriptor like separate processes.
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	if err := ioutil.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				lock, err := LockFile(path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				content, _ := ioutil.ReadFile(path)
+				counter, _ := strconv.Atoi(string(content))
+				_ = ioutil.WriteFile(path, []byte(strconv.Itoa(counter+1)), 0644)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	content, _ := ioutil.ReadFile(path)
+	if string(content) != "200" {
+		t.Errorf("expected 200, got %s", content)
+	}
+}
@@ -0,0 +1,165 @@
+package mikrotik
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TStepFunc func(host *THost) error
+
+type TSteps map[string]*TStep
+type TStep struct {
+	Name string
+	Note string
+	Func TStepFunc
+}
+
+type TProfiles []*TProfile
+type TProfile struct {
+	Name      string         `json:"name"`
+	Sequence  TListOfStrings `json:"sequence"`
+	StepRetry TRetries       `json:"step_retry"`
+	Note      string         `json:"note"`
+}
+
+var Steps = TSteps{}
+
+// DefaultSequence is what hosts without a profile or sequence run. Steps
+// that change credentials on the device (sync_ssh_keys, rotate_passwords,
+// sync_breakglass) are not in it and have to be named in a profile or host.
+var DefaultSequence = TListOfStrings{
+	"clean_users",
+	"clean_groups",
+	"clean_schedules",
+	"add_groups",
+	"add_users",
+	"make_backup_folder",
+	"add_schedules",
+	"download_backup",
+}
+
+func init() {
+	_ = RegisterStep("clean_users", "cleaning users", (*THost).CleanUsers)
+	_ = RegisterStep("clean_groups", "cleaning groups", (*THost).CleanGroups)
+	_ = RegisterStep("clean_schedules", "cleaning schedules", (*THost).CleanSchedules)
+	_ = RegisterStep("add_groups", "adding groups", (*THost).AddGroups)
+	_ = RegisterStep("add_users", "adding users", (*THost).AddUsers)
+	_ = RegisterStep("make_backup_folder", "adding backup folder", (*THost).MakeBackupFolder)
+	_ = RegisterStep("add_schedules", "adding schedules", (*THost).AddSchedules)
+	_ = RegisterStep("download_backup", "backup directory", (*THost).DownloadBackupFolder)
+	_ = RegisterStep("make_backup", "making backup", (*THost).MakeBackupNow)
+	_ = RegisterStep("make_export", "making export", (*THost).MakeExportNow)
+	_ = RegisterStep("sync_ssh_keys", "synchronizing SSH keys", (*THost).SyncSshKeys)
+	_ = RegisterStep("rotate_passwords", "rotating passwords", (*THost).RotatePasswords)
+	_ = RegisterStep("sync_breakglass", "synchronizing break-glass user", (*THost).SyncBreakglass)
+	_ = RegisterStep("audit", "auditing users, groups and schedules", (*THost).Audit)
+}
+
+func RegisterStep(name string, note string, step TStepFunc) error {
+	if _, ok := Steps[name]; ok {
+		return errors.New(fmt.Sprintf("step \"%s\" already registered", name))
+	}
+	Steps[name] = &TStep{Name: name, Note: note, Func: step}
+	return nil
+}
+
+func (steps TSteps) GetByName(name string) (*TStep, error) {
+	step, ok := steps[name]
+	if !ok {
+		err := errors.New(fmt.Sprintf("step \"%s\" does not exist", name))
+		return &TStep{}, err
+	}
+	return step, nil
+}
+
+// CheckSequence makes an unknown step fail on load rather than in the middle
+// of a run, after the steps before it have already changed the device.
+func (steps TSteps) CheckSequence(sequence TListOfStrings) error {
+	for _, name := range sequence {
+		if _, err := steps.GetByName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (profiles TProfiles) Load() error {
+	for _, profile := range profiles {
+		err := Steps.CheckSequence(profile.Sequence)
+		if err != nil {
+			return errors.New(fmt.Sprintf("profile \"%s\": %s", profile.Name, err))
+		}
+	}
+	return nil
+}
+
+func (profiles TProfiles) GetByName(name string) (*TProfile, error) {
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	err := errors.New("profile does not exist")
+	return &TProfile{}, err
+}
+
+func (host *THost) RunSequence(sequence TListOfStrings) error {
+	for _, name := range sequence {
+		step, err := Steps.GetByName(name)
+		if err != nil {
+			return err
+		}
+		host.BeginStep(step.Name)
+		host.Log().Info("sequence for " + step.Note)
+		host.stepRetry = host.GetRetry(step.Name)
+		err = step.Func(host)
+		host.stepRetry = nil
+		host.EndStep(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (host *THost) GetBackupDir() (string, error) {
+	var dir, err = Params.GetByName("dir_backup")
+	if err != nil {
+		return "", err
+	}
+	dir.Value = strings.Replace(dir.Value, "{host.name}", host.Name, -1)
+	dir.Value = strings.Replace(dir.Value, "{host.ip}", host.IP, -1)
+	return dir.Value, nil
+}
+
+func (host *THost) DownloadBackupFolder() error {
+	dir, err := host.GetBackupDir()
+	if err != nil {
+		return err
+	}
+	return host.DownloadFolder(host.BackupFolder, dir, true)
+}
+
+func (host *THost) GetBackupName() string {
+	return host.BackupFolder + "/" + time.Now().Format("2006.01.02-150405")
+}
+
+func (host *THost) MakeBackupNow() error {
+	path, err := host.MakeBackup(host.GetBackupName())
+	if err != nil {
+		return err
+	}
+	host.Log().Info("backup saved", "file", path)
+	return nil
+}
+
+func (host *THost) MakeExportNow() error {
+	path, err := host.MakeExport(host.GetBackupName())
+	if err != nil {
+		return err
+	}
+	host.Log().Info("export saved", "file", path)
+	return nil
+}
@@ -0,0 +1,30 @@
+package mikrotik
+
+import "testing"
+
+func TestCheckSequence(t *testing.T) {
+	for name, sequence := range Actions {
+		if err := Steps.CheckSequence(sequence); err != nil {
+			t.Errorf("action %q: %s", name, err)
+		}
+	}
+	if err := Steps.CheckSequence(DefaultSequence); err != nil {
+		t.Errorf("default sequence: %s", err)
+	}
+	for _, step := range []string{"sync_ssh_keys", "rotate_passwords", "sync_breakglass"} {
+		if DefaultSequence.IsContain(step) || Actions["sync"].IsContain(step) {
+			t.Errorf("step %q runs without opt-in", step)
+		}
+	}
+	profiles := TProfiles{{Name: "typo", Sequence: TListOfStrings{"clean_users", "add_user"}}}
+	if err := profiles.Load(); err == nil {
+		t.Error("unknown step in a profile is accepted")
+	}
+	tasks := Tasks
+	Tasks = TTasks{{Name: "daily"}}
+	t.Cleanup(func() { Tasks = tasks })
+	host := &THost{IP: "10.0.0.1", Jobs: TJobs{{Name: "sync", TaskName: "daily", Sequence: TListOfStrings{"add_users", "backup"}}}}
+	if err := host.LoadJobs(); err == nil {
+		t.Error("unknown step in a job is accepted")
+	}
+}
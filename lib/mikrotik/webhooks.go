@@ -0,0 +1,201 @@
+package mikrotik
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type TWebhooks []*TWebhook
+type TWebhook struct {
+	Name      string            `json:"name"`
+	Enabled   bool              `json:"enabled"`
+	URL       string            `json:"url"`
+	Format    string            `json:"format"`
+	ChatID    string            `json:"chat_id"`
+	Channel   string            `json:"channel"`
+	Username  string            `json:"username"`
+	Events    TListOfStrings    `json:"events"`
+	Hosts     TListOfStrings    `json:"hosts"`
+	Template  string            `json:"template"`
+	Templates map[string]string `json:"templates"`
+	Headers   map[string]string `json:"headers"`
+	Timeout   int               `json:"timeout"`
+	Retry     *TRetry           `json:"retry"`
+	Note      string            `json:"note"`
+	templates map[string]*template.Template
+}
+
+const (
+	WebhookFormatJSON       = "json"
+	WebhookFormatSlack      = "slack"
+	WebhookFormatMattermost = "mattermost"
+	WebhookFormatTelegram   = "telegram"
+)
+
+const WebhookEventAll = "*"
+
+const DefaultWebhookTemplate = `[rosman] {{.Type}} {{.Host}} ({{.IP}}){{if .Job}} job "{{.Job}}"{{end}}: {{.Message}}`
+
+var Webhooks TWebhooks
+
+var DefaultWebhookRetry = &TRetry{MaxAttempts: 3, Delay: 2, MaxDelay: 30, Multiplier: 2, Jitter: 0.2}
+
+func init() {
+	AddListener(NotifyWebhooks)
+}
+
+func (webhooks TWebhooks) Load() error {
+	for _, webhook := range webhooks {
+		switch webhook.Format {
+		case "":
+			webhook.Format = WebhookFormatJSON
+		case WebhookFormatJSON, WebhookFormatSlack, WebhookFormatMattermost:
+		case WebhookFormatTelegram:
+			if webhook.ChatID == "" {
+				return errors.New(fmt.Sprintf("webhook \"%s\": chat_id is required for telegram format", webhook.Name))
+			}
+		default:
+			return errors.New(fmt.Sprintf("webhook \"%s\": format \"%s\" does not exist", webhook.Name, webhook.Format))
+		}
+		if webhook.URL == "" {
+			return errors.New(fmt.Sprintf("webhook \"%s\": url is required", webhook.Name))
+		}
+		source := webhook.Template
+		if source == "" {
+			source = DefaultWebhookTemplate
+		}
+		webhook.templates = map[string]*template.Template{}
+		parsed, err := template.New(webhook.Name).Parse(source)
+		if err != nil {
+			return errors.New(fmt.Sprintf("webhook \"%s\": %s", webhook.Name, err))
+		}
+		webhook.templates[WebhookEventAll] = parsed
+		for eventType, source := range webhook.Templates {
+			parsed, err = template.New(webhook.Name + ":" + eventType).Parse(source)
+			if err != nil {
+				return errors.New(fmt.Sprintf("webhook \"%s\": template for \"%s\": %s", webhook.Name, eventType, err))
+			}
+			webhook.templates[eventType] = parsed
+		}
+	}
+	return nil
+}
+
+func NotifyWebhooks(event TEvent) {
+	for _, webhook := range Webhooks {
+		if !webhook.Match(event) {
+			continue
+		}
+		webhook := webhook
+		Deliver(func() {
+			err := webhook.Deliver(event)
+			if err != nil {
+				Log.Error("webhook error", "component", "webhook", "webhook", webhook.Name, "event", event.Type, "host", event.Host, "error", err)
+			}
+		})
+	}
+}
+
+func (webhook *TWebhook) Match(event TEvent) bool {
+	if !webhook.Enabled {
+		return false
+	}
+	if len(webhook.Hosts) > 0 && !webhook.Hosts.IsContain(event.Host) && !webhook.Hosts.IsContain(event.IP) {
+		return false
+	}
+	return webhook.Events.IsContain(WebhookEventAll) || webhook.Events.IsContain(event.Type)
+}
+
+func (webhook *TWebhook) Render(event TEvent) (string, error) {
+	parsed, ok := webhook.templates[event.Type]
+	if !ok {
+		parsed = webhook.templates[WebhookEventAll]
+	}
+	var buffer bytes.Buffer
+	err := parsed.Execute(&buffer, event)
+	return buffer.String(), err
+}
+
+func (webhook *TWebhook) Payload(event TEvent) ([]byte, error) {
+	text, err := webhook.Render(event)
+	if err != nil {
+		return nil, err
+	}
+	switch webhook.Format {
+	case WebhookFormatSlack, WebhookFormatMattermost:
+		payload := map[string]string{"text": text}
+		if webhook.Channel != "" {
+			payload["channel"] = webhook.Channel
+		}
+		if webhook.Username != "" {
+			payload["username"] = webhook.Username
+		}
+		return json.Marshal(payload)
+	case WebhookFormatTelegram:
+		return json.Marshal(map[string]string{"chat_id": webhook.ChatID, "text": text})
+	}
+	return json.Marshal(struct {
+		TEvent
+		Text string `json:"text"`
+	}{event, text})
+}
+
+func (webhook *TWebhook) Deliver(event TEvent) error {
+	payload, err := webhook.Payload(event)
+	if err != nil {
+		return err
+	}
+	retry := webhook.Retry
+	if retry == nil {
+		retry = DefaultWebhookRetry
+	}
+	for attempt := 1; ; attempt++ {
+		err = webhook.post(payload)
+		if err == nil {
+			return nil
+		}
+		if ClassifyError(err) == ErrorPermanent || retry.IsExhausted(attempt) {
+			return err
+		}
+		time.Sleep(retry.GetDelay(attempt))
+	}
+}
+
+func (webhook *TWebhook) post(payload []byte) error {
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return &TPermanentError{Err: err}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "rosman")
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode >= 300 {
+		err = errors.New(fmt.Sprintf("webhook \"%s\" returned %s: %s", webhook.Name, response.Status, strings.TrimSpace(string(body))))
+		if response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+			return &TPermanentError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
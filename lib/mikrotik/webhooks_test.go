@@ -0,0 +1,190 @@
+package mikrotik
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tWebhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	status   []int
+	requests []map[string]interface{}
+	headers  []http.Header
+}
+
+// newWebhookServer answers with the given statuses in order and 200 after
+// them, every request body is kept.
+func newWebhookServer(t *testing.T, status ...int) *tWebhookServer {
+	server := &tWebhookServer{status: status}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("payload is not JSON: %s", body)
+		}
+		server.mutex.Lock()
+		server.requests = append(server.requests, payload)
+		server.headers = append(server.headers, request.Header.Clone())
+		code := http.StatusOK
+		if len(server.status) > 0 {
+			code, server.status = server.status[0], server.status[1:]
+		}
+		server.mutex.Unlock()
+		writer.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testEvent() TEvent {
+	return TEvent{Type: EventObjectRemoved, Time: 1700000000, Host: "router", IP: "10.0.0.1", Job: "sync_users", RunID: "run-1",
+		Message: "user \"bob\" removed (not in config)", Data: map[string]string{"kind": "user", "name": "bob"}}
+}
+
+func TestWebhookFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook TWebhook
+		check   func(t *testing.T, payload map[string]interface{})
+	}{
+		{
+			name:    "json",
+			webhook: TWebhook{Format: WebhookFormatJSON},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["type"] != EventObjectRemoved || payload["host"] != "router" || payload["run_id"] != "run-1" {
+					t.Errorf("event fields are missing: %v", payload)
+				}
+				if payload["text"] != `[rosman] object_removed router (10.0.0.1) job "sync_users": user "bob" removed (not in config)` {
+					t.Errorf("unexpected text %q", payload["text"])
+				}
+			},
+		},
+		{
+			name:    "slack",
+			webhook: TWebhook{Format: WebhookFormatSlack, Channel: "#net", Username: "rosman", Template: "{{.Host}}: {{.Message}}"},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["text"] != `router: user "bob" removed (not in config)` || payload["channel"] != "#net" || payload["username"] != "rosman" {
+					t.Errorf("unexpected payload %v", payload)
+				}
+			},
+		},
+		{
+			name: "telegram with per event template",
+			webhook: TWebhook{Format: WebhookFormatTelegram, ChatID: "-100", Template: "{{.Message}}",
+				Templates: map[string]string{EventObjectRemoved: "removed {{.Data.kind}} {{.Data.name}}"}},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["chat_id"] != "-100" || payload["text"] != "removed user bob" {
+					t.Errorf("unexpected payload %v", payload)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newWebhookServer(t)
+			webhook := test.webhook
+			webhook.Name, webhook.Enabled, webhook.URL = test.name, true, server.URL
+			webhook.Events = TListOfStrings{WebhookEventAll}
+			webhook.Headers = map[string]string{"X-Token": "secret"}
+			err := TWebhooks{&webhook}.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = webhook.Deliver(testEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(server.requests) != 1 {
+				t.Fatalf("expected 1 request, got %d", len(server.requests))
+			}
+			if server.headers[0].Get("X-Token") != "secret" || server.headers[0].Get("Content-Type") != "application/json" {
+				t.Errorf("headers are not sent: %v", server.headers[0])
+			}
+			test.check(t, server.requests[0])
+		})
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   []int
+		attempts int
+		fails    bool
+	}{
+		{"server error is retried", []int{500, 502}, 3, false},
+		{"too many requests is retried", []int{429}, 2, false},
+		{"attempts are limited", []int{503, 503, 503, 503}, 3, true},
+		{"client error is permanent", []int{400}, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newWebhookServer(t, test.status...)
+			webhook := &TWebhook{Name: "retry", Enabled: true, URL: server.URL, Retry: &TRetry{MaxAttempts: 3}}
+			err := TWebhooks{webhook}.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = webhook.Deliver(testEvent())
+			if (err != nil) != test.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+			if len(server.requests) != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, len(server.requests))
+			}
+		})
+	}
+}
+
+func TestWebhookMatch(t *testing.T) {
+	webhook := &TWebhook{Enabled: true, Events: TListOfStrings{EventRunFailed}, Hosts: TListOfStrings{"10.0.0.1"}}
+	event := testEvent()
+	if webhook.Match(event) {
+		t.Error("event type is not routed to the webhook")
+	}
+	event.Type = EventRunFailed
+	if !webhook.Match(event) {
+		t.Error("routed event does not match")
+	}
+	event.IP, event.Host = "10.0.0.2", "other"
+	if webhook.Match(event) {
+		t.Error("event of another host matches")
+	}
+}
+
+func TestWaitDeliveries(t *testing.T) {
+	server := newWebhookServer(t, http.StatusBadGateway)
+	webhook := &TWebhook{Name: "cli", Enabled: true, URL: server.URL, Events: TListOfStrings{WebhookEventAll}, Retry: &TRetry{MaxAttempts: 2}}
+	if err := (TWebhooks{webhook}).Load(); err != nil {
+		t.Fatal(err)
+	}
+	saved := Webhooks
+	Webhooks = TWebhooks{webhook}
+	t.Cleanup(func() { Webhooks = saved })
+	// a CLI command returns right after emitting, the retried delivery must
+	// still reach the server before it exits
+	NotifyWebhooks(testEvent())
+	if !WaitDeliveries(time.Minute) {
+		t.Fatal("deliveries are not done")
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.requests) != 2 {
+		t.Errorf("expected 2 attempts, got %d", len(server.requests))
+	}
+	release := make(chan struct{})
+	Deliver(func() { <-release })
+	if WaitDeliveries(10 * time.Millisecond) {
+		t.Error("pending delivery is not waited for")
+	}
+	close(release)
+	if !WaitDeliveries(time.Minute) {
+		t.Error("released delivery is not done")
+	}
+}
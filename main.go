@@ -1,11 +1,43 @@
 package main
 
 import (
+	"log"
+	"os"
 	"rosman/lib/mikrotik"
 	"time"
+	_ "time/tzdata"
 )
 
+// deliveryWait bounds how long a CLI command waits for its notifications.
+const deliveryWait = 2 * time.Minute
+
 func main() {
+	var err error
+	mikrotik.LogRotation = len(os.Args) < 2
+	if len(os.Args) > 2 && os.Args[1] == "secrets" && os.Args[2] == "init-key" {
+		// the key may be what the rest of the config is missing
+		err = mikrotik.LoadParams()
+	} else {
+		err = mikrotik.LoadConfig()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(os.Args) > 1 {
+		err = RunCommand(os.Args[1:])
+		if !mikrotik.WaitDeliveries(deliveryWait) {
+			mikrotik.Log.Warn("notifications are still being delivered, exiting without them")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	mikrotik.StartMetricsServer()
+	mikrotik.StartAPIServer()
+	mikrotik.StartDigest()
+	mikrotik.StartGrants()
+	mikrotik.StartBreakglass()
 	for _, host := range mikrotik.Hosts {
 		go host.Run()
 	}